@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("cache")
+
+const boltSweep = 10 * time.Minute
+
+//embedded on-disk cache for deployments without redis,
+//values are stored with the unix nano expiry in front, 0 means no expiry
+type Bolt struct {
+	db   *bolt.DB
+	done chan struct{}
+}
+
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		//release the file lock so the path can be opened again
+		db.Close()
+		return nil, errors.Wrap(err, "CreateBucketIfNotExists")
+	}
+	b := &Bolt{db: db, done: make(chan struct{})}
+	go b.sweep()
+	return b, nil
+}
+
+func (b *Bolt) Close() error {
+	close(b.done)
+	return b.db.Close()
+}
+
+//drop expired keys from time to time
+func (b *Bolt) sweep() {
+	ticker := time.NewTicker(boltSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			_ = b.removeExpired(now)
+		}
+	}
+}
+
+//keys are collected first, deleting under the cursor makes it skip the next key
+func (b *Bolt) removeExpired(now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		var keys [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if expired(v, now) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encode(val string, ttl time.Duration) []byte {
+	out := make([]byte, 8+len(val))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(out, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(out[8:], val)
+	return out
+}
+
+func expired(v []byte, now time.Time) bool {
+	if len(v) < 8 {
+		return true
+	}
+	exp := binary.BigEndian.Uint64(v)
+	return exp != 0 && int64(exp) <= now.UnixNano()
+}
+
+func (b *Bolt) set(key, val string, ttl time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), encode(val, ttl))
+	})
+}
+
+//value and whether the key exists and is not expired
+func (b *Bolt) get(key string) (string, bool, error) {
+	var out string
+	var ok bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil || expired(v, time.Now()) {
+			return nil
+		}
+		out, ok = string(v[8:]), true
+		return nil
+	})
+	return out, ok, err
+}
+
+//set only when there is no live value, like redis SETNX
+func (b *Bolt) setNX(key, val string, ttl time.Duration) (bool, error) {
+	set := false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		v := bucket.Get([]byte(key))
+		if v != nil && !expired(v, time.Now()) {
+			return nil
+		}
+		set = true
+		return bucket.Put([]byte(key), encode(val, ttl))
+	})
+	return set, err
+}
+
+func (b *Bolt) SetUser(chatId int64, msgId int, userId int64) error {
+	return b.set(join("fwd", chatId, msgId), strconv.FormatInt(userId, 10), 0)
+}
+
+func (b *Bolt) GetUser(chatId int64, msgId int) (int64, error) {
+	idStr, ok, err := b.get(join("fwd", chatId, msgId))
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(idStr, 10, 64)
+}
+
+func (b *Bolt) SetBan(userId int64) error {
+	return b.set(join("ban", userId), "1", time.Hour*100)
+}
+
+func (b *Bolt) GetBan(userId int64) (bool, error) {
+	_, ok, err := b.get(join("ban", userId))
+	return ok, err
+}
+
+func (b *Bolt) SetAnswered(chatId int64, msgId int, admin string) error {
+	return b.set(join("answered", chatId, msgId), admin, 0)
+}
+
+func (b *Bolt) GetAnswered(chatId int64, msgId int) (string, error) {
+	admin, _, err := b.get(join("answered", chatId, msgId))
+	return admin, err
+}
+
+func (b *Bolt) SetOffHours(userId int64, ttl time.Duration) (bool, error) {
+	return b.setNX(join("offhours", userId), "1", ttl)
+}
+
+func (b *Bolt) SetAcked(userId int64, ttl time.Duration) (bool, error) {
+	return b.setNX(join("acked", userId), "1", ttl)
+}
+
+func (b *Bolt) SetAssigned(userId int64, admin string) error {
+	return b.set(join("assigned", userId), admin, 0)
+}
+
+func (b *Bolt) GetAssigned(userId int64) (string, error) {
+	admin, _, err := b.get(join("assigned", userId))
+	return admin, err
+}
+
+func (b *Bolt) SetSLANotified(userId int64, since time.Time, level int) (bool, error) {
+	return b.setNX(join("sla", userId, since.Unix(), level), "1", 7*24*time.Hour)
+}
+
+func (b *Bolt) ClearSLANotified(userId int64, since time.Time, level int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(join("sla", userId, since.Unix(), level)))
+	})
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRemoveExpired(t *testing.T) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	//neighbours expire together, the cursor used to skip every second one
+	for i := 0; i < 10; i++ {
+		ttl := time.Millisecond
+		if i%5 == 4 {
+			ttl = 0
+		}
+		if err := b.set(strconv.Itoa(i), "v", ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.removeExpired(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		_, ok, err := b.get(strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i%5 == 4; ok != want {
+			t.Errorf("key %v kept = %v, want %v", i, ok, want)
+		}
+	}
+	//get ignores expired values, the keys must be gone from the file too
+	n := 0
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%v keys left, want 2", n)
+	}
+}
+
+func TestClearSLANotified(t *testing.T) {
+	b, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	since := time.Unix(1600000000, 0)
+	for i, want := range []bool{true, false} {
+		first, err := b.SetSLANotified(1, since, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != want {
+			t.Fatalf("call %v: first = %v, want %v", i, first, want)
+		}
+	}
+	if err := b.ClearSLANotified(1, since, 1); err != nil {
+		t.Fatal(err)
+	}
+	first, err := b.SetSLANotified(1, since, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Error("level still notified after ClearSLANotified")
+	}
+}
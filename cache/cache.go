@@ -2,33 +2,60 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
-	"fmt"
 
 	"github.com/go-redis/redis/v8"
 )
 
+const DefaultPrefix = "tgbase"
+
+//keys are <prefix>:<kind>:<ids...> so several bots can share one redis
 type Cache struct {
-	ctx      context.Context
-	db       *redis.Client
-	keepTime int64
+	ctx    context.Context
+	db     redis.UniversalClient
+	prefix string
 }
 
-func New(ctx context.Context, db *redis.Client, keepTime int64) *Cache {
+func New(ctx context.Context, db redis.UniversalClient, prefix string) *Cache {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
 	return &Cache{
-		ctx:      ctx,
-		db:       db,
-		keepTime: keepTime,
+		ctx:    ctx,
+		db:     db,
+		prefix: prefix,
 	}
 }
 
-func (c *Cache) SetUser(msgId int, userId int64, ) error {
-	return c.db.Set(c.ctx, strconv.Itoa(msgId), userId, time.Duration(int64(time.Hour)*c.keepTime)).Err()
+func (c *Cache) key(kind string, ids ...interface{}) string {
+	return join(append([]interface{}{c.prefix, kind}, ids...)...)
 }
 
-func (c *Cache) GetUser(msgId int) (int64, error) {
-	idStr, err := c.db.Get(c.ctx, strconv.Itoa(msgId)).Result()
+func join(parts ...interface{}) string {
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, fmt.Sprint(p))
+	}
+	return strings.Join(out, ":")
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+//forwarded message to user mapping, kept without expiry
+func (c *Cache) SetUser(chatId int64, msgId int, userId int64) error {
+	return c.db.Set(c.ctx, c.key("fwd", chatId, msgId), userId, 0).Err()
+}
+
+func (c *Cache) GetUser(chatId int64, msgId int) (int64, error) {
+	idStr, err := c.db.Get(c.ctx, c.key("fwd", chatId, msgId)).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -36,25 +63,50 @@ func (c *Cache) GetUser(msgId int) (int64, error) {
 }
 
 func (c *Cache) SetBan(userId int64) error {
-	idStr := strconv.FormatInt(userId, 10)
-	return c.db.Set(c.ctx, idStr, true, time.Hour*100).Err()
+	return c.db.Set(c.ctx, c.key("ban", userId), true, time.Hour*100).Err()
 }
 
 func (c *Cache) GetBan(userId int64) (bool, error) {
-	idStr := strconv.FormatInt(userId, 10)
-	return c.db.Get(c.ctx, idStr).Bool()
+	return c.db.Get(c.ctx, c.key("ban", userId)).Bool()
 }
 
-func (c *Cache) SetAnswered(msgId int, admin string) error {
-	key := fmt.Sprintf("answered/%v", msgId)
-	return c.db.Set(c.ctx, key, admin, 0).Err()
+func (c *Cache) SetAnswered(chatId int64, msgId int, admin string) error {
+	return c.db.Set(c.ctx, c.key("answered", chatId, msgId), admin, 0).Err()
 }
 
-func (c *Cache) GetAnswered(msgId int) (string, error) {
-	key := fmt.Sprintf("answered/%v", msgId)
-	admin, err := c.db.Get(c.ctx, key).Result()
+func (c *Cache) GetAnswered(chatId int64, msgId int) (string, error) {
+	admin, err := c.db.Get(c.ctx, c.key("answered", chatId, msgId)).Result()
 	if err == redis.Nil {
 		return "", nil
 	}
 	return admin, err
 }
+
+func (c *Cache) SetOffHours(userId int64, ttl time.Duration) (bool, error) {
+	return c.db.SetNX(c.ctx, c.key("offhours", userId), true, ttl).Result()
+}
+
+func (c *Cache) SetAcked(userId int64, ttl time.Duration) (bool, error) {
+	return c.db.SetNX(c.ctx, c.key("acked", userId), true, ttl).Result()
+}
+
+func (c *Cache) SetAssigned(userId int64, admin string) error {
+	return c.db.Set(c.ctx, c.key("assigned", userId), admin, 0).Err()
+}
+
+func (c *Cache) GetAssigned(userId int64) (string, error) {
+	admin, err := c.db.Get(c.ctx, c.key("assigned", userId)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return admin, err
+}
+
+func (c *Cache) SetSLANotified(userId int64, since time.Time, level int) (bool, error) {
+	key := c.key("sla", userId, since.Unix(), level)
+	return c.db.SetNX(c.ctx, key, true, 7*24*time.Hour).Result()
+}
+
+func (c *Cache) ClearSLANotified(userId int64, since time.Time, level int) error {
+	return c.db.Del(c.ctx, c.key("sla", userId, since.Unix(), level)).Err()
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+type MigrateReport struct {
+	Renamed  int
+	Skipped  int
+	Prefixed int
+	//keys of an old shape that can't be mapped
+	Unknown []string
+	//old keys left in place because the new key already exists
+	Conflicts []string
+}
+
+//old key shapes, other keys in a shared redis are not touched
+var oldPatterns = []string{"fwd/*", "answered/*", "offhours/*", "acked/*", "assigned/*", "sla/*", "[0-9]*"}
+
+//longest expiry of the old bare ban flags
+const oldBanTTL = 100 * time.Hour
+
+//move keys of the old slash separated and bare id schemes into the prefixed one,
+//every master is scanned in cluster mode
+func (c *Cache) MigrateKeys(dryRun bool) (*MigrateReport, error) {
+	report := &MigrateReport{}
+	if cluster, ok := c.db.(*redis.ClusterClient); ok {
+		//masters are scanned concurrently, each into its own report
+		var mu sync.Mutex
+		err := cluster.ForEachMaster(c.ctx, func(ctx context.Context, node *redis.Client) error {
+			nodeReport := &MigrateReport{}
+			err := c.migrateNode(node, dryRun, nodeReport)
+			mu.Lock()
+			report.add(nodeReport)
+			mu.Unlock()
+			return err
+		})
+		return report, err
+	}
+	return report, c.migrateNode(c.db, dryRun, report)
+}
+
+func (r *MigrateReport) add(other *MigrateReport) {
+	r.Renamed += other.Renamed
+	r.Skipped += other.Skipped
+	r.Prefixed += other.Prefixed
+	r.Unknown = append(r.Unknown, other.Unknown...)
+	r.Conflicts = append(r.Conflicts, other.Conflicts...)
+}
+
+func (c *Cache) migrateNode(node redis.UniversalClient, dryRun bool, report *MigrateReport) error {
+	for _, pattern := range oldPatterns {
+		iter := node.Scan(c.ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(c.ctx) {
+			old := iter.Val()
+			newKey, ours, err := c.migratedKey(old)
+			if err != nil {
+				return errors.Wrapf(err, "migratedKey %v", old)
+			}
+			if !ours {
+				continue
+			}
+			if newKey == "" {
+				report.Skipped++
+				report.Unknown = append(report.Unknown, old)
+				continue
+			}
+			moved, err := c.move(old, newKey, dryRun)
+			if err != nil {
+				return errors.Wrapf(err, "move %v", old)
+			}
+			if !moved {
+				report.Conflicts = append(report.Conflicts, old+" -> "+newKey)
+				continue
+			}
+			report.Renamed++
+		}
+		if err := iter.Err(); err != nil {
+			return errors.Wrapf(err, "Scan %v", pattern)
+		}
+	}
+
+	iter := node.Scan(c.ctx, 0, c.prefix+":*", 1000).Iterator()
+	for iter.Next(c.ctx) {
+		report.Prefixed++
+	}
+	if err := iter.Err(); err != nil {
+		return errors.Wrap(err, "Scan prefixed")
+	}
+	return nil
+}
+
+//copy value and expiry with DUMP/RESTORE, keys may live on different cluster
+//slots. False when newKey already exists, then old is kept
+func (c *Cache) move(old, newKey string, dryRun bool) (bool, error) {
+	exists, err := c.db.Exists(c.ctx, newKey).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "Exists")
+	}
+	if exists != 0 {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	dump, err := c.db.Dump(c.ctx, old).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "Dump")
+	}
+	ttl, err := c.db.PTTL(c.ctx, old).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "PTTL")
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	err = c.db.Restore(c.ctx, newKey, ttl, dump).Err()
+	if err != nil {
+		return false, errors.Wrap(err, "Restore")
+	}
+	return true, c.db.Del(c.ctx, old).Err()
+}
+
+//new key for an old one, empty when it is the bot's but can't be migrated,
+//not ours when the key only looks like an old one
+func (c *Cache) migratedKey(old string) (string, bool, error) {
+	parts := strings.Split(old, "/")
+	for _, p := range parts[1:] {
+		if _, err := strconv.ParseInt(p, 10, 64); err != nil {
+			return "", false, nil
+		}
+	}
+	ids := make([]interface{}, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		ids = append(ids, p)
+	}
+
+	switch {
+	case len(parts) == 3 && (parts[0] == "fwd" || parts[0] == "answered"):
+		return c.key(parts[0], ids...), true, nil
+	case len(parts) == 2 && (parts[0] == "offhours" || parts[0] == "acked" || parts[0] == "assigned"):
+		return c.key(parts[0], ids...), true, nil
+	case len(parts) == 4 && parts[0] == "sla":
+		return c.key(parts[0], ids...), true, nil
+	case len(parts) == 1:
+		//bare user id holds a ban flag with an expiry, bare message id a
+		//user id of unknown admin chat. Both look like keys of other apps,
+		//so only ban flags are taken
+		if _, err := strconv.ParseInt(old, 10, 64); err != nil {
+			return "", false, nil
+		}
+		val, err := c.db.Get(c.ctx, old).Result()
+		if err == redis.Nil {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, errors.Wrap(err, "Get")
+		}
+		ttl, err := c.db.PTTL(c.ctx, old).Result()
+		if err != nil {
+			return "", false, errors.Wrap(err, "PTTL")
+		}
+		if val == "1" && ttl > 0 && ttl <= oldBanTTL {
+			return c.key("ban", old), true, nil
+		}
+		return "", false, nil
+	}
+	return "", true, nil
+}
@@ -0,0 +1,346 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	width  = 800
+	height = 450
+
+	marginLeft   = 60
+	marginRight  = 20
+	marginTop    = 50
+	marginBottom = 50
+
+	fontSize  = 13
+	titleSize = 18
+
+	//horizontal grid lines
+	ticks = 5
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	axis       = color.RGBA{0x44, 0x44, 0x44, 0xff}
+	grid       = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+
+	//colors of series in order
+	Blue   = color.RGBA{0x3b, 0x7d, 0xd8, 0xff}
+	Orange = color.RGBA{0xf0, 0x8c, 0x2e, 0xff}
+	Green  = color.RGBA{0x3a, 0xa6, 0x5c, 0xff}
+)
+
+//values of one line or one color of bars
+type Series struct {
+	Name   string
+	Values []float64
+	Color  color.RGBA
+}
+
+//go fonts have cyrillic, parsed once
+var (
+	fontOnce sync.Once
+	fontErr  error
+	regular  *opentype.Font
+)
+
+func face(size float64) (font.Face, error) {
+	fontOnce.Do(func() {
+		regular, fontErr = opentype.Parse(goregular.TTF)
+	})
+	if fontErr != nil {
+		return nil, errors.Wrap(fontErr, "Parse")
+	}
+	return opentype.NewFace(regular, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+type canvas struct {
+	img   *image.RGBA
+	text  font.Face
+	title font.Face
+}
+
+func newCanvas(title string) (*canvas, error) {
+	text, err := face(fontSize)
+	if err != nil {
+		return nil, err
+	}
+	big, err := face(titleSize)
+	if err != nil {
+		return nil, err
+	}
+	c := &canvas{img: image.NewRGBA(image.Rect(0, 0, width, height)), text: text, title: big}
+	draw.Draw(c.img, c.img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	c.write(c.title, (width-c.measure(c.title, title))/2, 30, title, axis)
+	return c, nil
+}
+
+func (c *canvas) measure(f font.Face, s string) int {
+	return font.MeasureString(f, s).Round()
+}
+
+//s with the baseline at y
+func (c *canvas) write(f font.Face, x, y int, s string, col color.Color) {
+	d := font.Drawer{Dst: c.img, Src: image.NewUniform(col), Face: f, Dot: fixed.P(x, y)}
+	d.DrawString(s)
+}
+
+func (c *canvas) rect(r image.Rectangle, col color.Color) {
+	draw.Draw(c.img, r, image.NewUniform(col), image.Point{}, draw.Over)
+}
+
+//line two pixels wide
+func (c *canvas) line(x0, y0, x1, y1 int, col color.Color) {
+	dx, dy := x1-x0, y1-y0
+	steps := abs(dx)
+	if abs(dy) > steps {
+		steps = abs(dy)
+	}
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		x := x0 + dx*i/steps
+		y := y0 + dy*i/steps
+		c.rect(image.Rect(x, y, x+2, y+2), col)
+	}
+}
+
+//legend of several series in the top right corner
+func (c *canvas) legend(series []Series) {
+	if len(series) < 2 {
+		return
+	}
+	x := width - marginRight
+	for i := len(series) - 1; i >= 0; i-- {
+		x -= c.measure(c.text, series[i].Name)
+		c.write(c.text, x, marginTop-8, series[i].Name, axis)
+		x -= 16
+		c.rect(image.Rect(x, marginTop-18, x+12, marginTop-8), series[i].Color)
+		x -= 12
+	}
+}
+
+func (c *canvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, c.img)
+	if err != nil {
+		return nil, errors.Wrap(err, "Encode")
+	}
+	return buf.Bytes(), nil
+}
+
+//plot area with a value axis from 0 to a round number above max,
+//returns the area and the scale
+func (c *canvas) valueAxis(max float64) (image.Rectangle, float64) {
+	area := image.Rect(marginLeft, marginTop, width-marginRight, height-marginBottom)
+	step := niceStep(max / ticks)
+	top := step * ticks
+	for i := 0; i <= ticks; i++ {
+		y := area.Max.Y - int(float64(area.Dy())*float64(i)/ticks)
+		c.rect(image.Rect(area.Min.X, y, area.Max.X, y+1), grid)
+		label := formatValue(step * float64(i))
+		c.write(c.text, area.Min.X-8-c.measure(c.text, label), y+4, label, axis)
+	}
+	c.rect(image.Rect(area.Min.X, area.Min.Y, area.Min.X+1, area.Max.Y), axis)
+	c.rect(image.Rect(area.Min.X, area.Max.Y, area.Max.X, area.Max.Y+1), axis)
+	return area, float64(area.Dy()) / top
+}
+
+//labels under the points at xs, some are skipped so that they do not overlap
+func (c *canvas) labels(labels []string, xs []int, y int) {
+	widest := 0
+	for _, l := range labels {
+		if w := c.measure(c.text, l); w > widest {
+			widest = w
+		}
+	}
+	every := 1
+	if len(xs) > 1 {
+		//points can be closer than a pixel
+		gap := xs[1] - xs[0]
+		if gap < 1 {
+			gap = 1
+		}
+		every = (widest + 8 + gap - 1) / gap
+	}
+	for i, l := range labels {
+		if i%every != 0 {
+			continue
+		}
+		c.write(c.text, xs[i]-c.measure(c.text, l)/2, y, l, axis)
+	}
+}
+
+//vertical bars, several series are grouped by label
+func Bars(title string, labels []string, series ...Series) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	area, scale := c.valueAxis(maxValue(series))
+	c.legend(series)
+	if len(labels) == 0 || len(series) == 0 {
+		return c.png()
+	}
+
+	slot := float64(area.Dx()) / float64(len(labels))
+	bar := int(slot * 0.8 / float64(len(series)))
+	if bar < 1 {
+		bar = 1
+	}
+	xs := make([]int, len(labels))
+	for i := range labels {
+		xs[i] = area.Min.X + int(slot*(float64(i)+0.5))
+		left := xs[i] - bar*len(series)/2
+		for j, s := range series {
+			if i >= len(s.Values) {
+				continue
+			}
+			h := int(s.Values[i] * scale)
+			x := left + bar*j
+			c.rect(image.Rect(x, area.Max.Y-h, x+bar, area.Max.Y), s.Color)
+		}
+	}
+	c.labels(labels, xs, area.Max.Y+20)
+	return c.png()
+}
+
+//one line per series, points are spread evenly over the labels
+func Line(title string, labels []string, series ...Series) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	area, scale := c.valueAxis(maxValue(series))
+	c.legend(series)
+	if len(labels) == 0 {
+		return c.png()
+	}
+
+	xs := make([]int, len(labels))
+	for i := range labels {
+		xs[i] = area.Min.X + area.Dx()/2
+		if len(labels) > 1 {
+			xs[i] = area.Min.X + 10 + (area.Dx()-20)*i/(len(labels)-1)
+		}
+	}
+	for _, s := range series {
+		prev := -1
+		for i, v := range s.Values {
+			if i >= len(xs) {
+				break
+			}
+			//gaps are days without data
+			if math.IsNaN(v) {
+				prev = -1
+				continue
+			}
+			y := area.Max.Y - int(v*scale)
+			c.rect(image.Rect(xs[i]-3, y-3, xs[i]+4, y+4), s.Color)
+			if prev >= 0 {
+				c.line(xs[prev], area.Max.Y-int(s.Values[prev]*scale), xs[i], y, s.Color)
+			}
+			prev = i
+		}
+	}
+	c.labels(labels, xs, area.Max.Y+20)
+	return c.png()
+}
+
+//horizontal bars with the label and the value on every bar
+func HorizontalBars(title string, labels []string, values []float64, col color.RGBA) ([]byte, error) {
+	c, err := newCanvas(title)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return c.png()
+	}
+	max := maxValue([]Series{{Values: values}})
+	top, bottom := marginTop, height-marginBottom/2
+	slot := (bottom - top) / len(labels)
+	bar := slot * 3 / 4
+	if bar > 40 {
+		bar = 40
+	}
+	labelWidth := 0
+	for _, l := range labels {
+		if w := c.measure(c.text, l); w > labelWidth {
+			labelWidth = w
+		}
+	}
+	if labelWidth > width/3 {
+		labelWidth = width / 3
+	}
+	left := marginRight + labelWidth + 8
+	right := width - marginRight - 60
+	for i, l := range labels {
+		y := top + slot*i
+		c.write(c.text, left-8-c.measure(c.text, l), y+bar/2+5, l, axis)
+		if i >= len(values) {
+			continue
+		}
+		w := int(values[i] / max * float64(right-left))
+		c.rect(image.Rect(left, y, left+w, y+bar), col)
+		c.write(c.text, left+w+6, y+bar/2+5, formatValue(values[i]), axis)
+	}
+	return c.png()
+}
+
+func maxValue(series []Series) float64 {
+	max := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			if v > max {
+				max = v
+			}
+		}
+	}
+	if max == 0 {
+		return 1
+	}
+	return max
+}
+
+//1, 2 or 5 times a power of ten, not less than v
+func niceStep(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	pow := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if m*pow >= v {
+			return m * pow
+		}
+	}
+	return 10 * pow
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
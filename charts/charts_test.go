@@ -0,0 +1,69 @@
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestChartsManyLabels(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 62, 365, 707, 5000} {
+		labels := make([]string, n)
+		values := make([]float64, n)
+		for i := range labels {
+			labels[i] = fmt.Sprintf("%02d.%02d", i%28+1, i%12+1)
+			values[i] = float64(i % 17)
+		}
+		charts := map[string]func() ([]byte, error){
+			"Line": func() ([]byte, error) {
+				return Line("линия", labels, Series{Name: "a", Values: values, Color: Green})
+			},
+			"Bars": func() ([]byte, error) {
+				return Bars("столбцы", labels, Series{Name: "a", Values: values, Color: Blue}, Series{Name: "b", Values: values, Color: Orange})
+			},
+			"HorizontalBars": func() ([]byte, error) { return HorizontalBars("регионы", labels, values, Blue) },
+		}
+		for name, draw := range charts {
+			done := make(chan error, 1)
+			go func() {
+				b, err := draw()
+				if err == nil {
+					_, err = png.Decode(bytes.NewReader(b))
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Errorf("%v with %v labels: %v", name, n, err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("%v with %v labels does not finish", name, n)
+			}
+		}
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, 1},
+		{-3, 1},
+		{0.2, 0.2},
+		{0.3, 0.5},
+		{1, 1},
+		{1.5, 2},
+		{3, 5},
+		{7, 10},
+		{12, 20},
+		{480, 500},
+	}
+	for _, tt := range tests {
+		if got := niceStep(tt.in); got < tt.want*0.999 || got > tt.want*1.001 {
+			t.Errorf("niceStep(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/CookieNyanCloud/tg-connection-base/cache"
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/CookieNyanCloud/tg-connection-base/pkg"
+	"github.com/pkg/errors"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+//move cache keys to the prefixed scheme: migrate-cache [-dry-run]
+func migrateCache(c *cache.Cache, args []string) error {
+	fs := flag.NewFlagSet("migrate-cache", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be renamed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report, err := c.MigrateKeys(*dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("renamed: %v, already prefixed: %v, skipped: %v\n",
+		report.Renamed, report.Prefixed, report.Skipped)
+	for _, key := range report.Unknown {
+		fmt.Printf("skipped %v\n", key)
+	}
+	for _, key := range report.Conflicts {
+		fmt.Printf("conflict %v: new key exists, old one kept\n", key)
+	}
+	return nil
+}
+
+//env vars of the table spreadsheets
+var sheetVars = map[string]string{
+	database.TableUsers:      "SHEET_USERS",
+	database.TableMsg:        "SHEET_MSG",
+	database.TableAdmins:     "SHEET_ADMINS",
+	database.TableBanned:     "SHEET_BANNED",
+	database.TableCanned:     "SHEET_CANNED",
+	database.TableHistory:    "SHEET_HISTORY",
+	database.TableNotes:      "SHEET_NOTES",
+	database.TableTags:       "SHEET_TAGS",
+	database.TableBroadcasts: "SHEET_BROADCASTS",
+}
+
+//spreadsheets of the tables
+func sheetIds(conf config.SheetsConfig) map[string]string {
+	return map[string]string{
+		database.TableUsers:      conf.Users,
+		database.TableMsg:        conf.Msg,
+		database.TableAdmins:     conf.Admins,
+		database.TableBanned:     conf.Banned,
+		database.TableCanned:     conf.Canned,
+		database.TableHistory:    conf.History,
+		database.TableNotes:      conf.Notes,
+		database.TableTags:       conf.Tags,
+		database.TableBroadcasts: conf.Broadcasts,
+	}
+}
+
+//create or check the spreadsheets: init-sheets [-check] [-title name] [-share email]
+func initSheets(ctx context.Context, srv *sheets.Service, ids map[string]string, schema database.Schema, args []string) error {
+	fs := flag.NewFlagSet("init-sheets", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report what is missing")
+	title := fs.String("title", "tg-connection-base", "title prefix of new spreadsheets")
+	share := fs.String("share", "", "email to give edit access to new spreadsheets")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	report, err := database.InitSheets(srv, ids, schema, *title, *check)
+	if err != nil {
+		return err
+	}
+	for _, line := range report.Created {
+		fmt.Printf("created %v\n", line)
+	}
+	for _, line := range report.Mismatches {
+		fmt.Printf("mismatch %v\n", line)
+	}
+
+	if *share != "" && !*check {
+		drv, err := drive.NewService(ctx, option.WithCredentialsFile("sheets.json"))
+		if err != nil {
+			return errors.Wrap(err, "drive")
+		}
+		shared := make(map[string]bool)
+		for table, id := range report.Ids {
+			//only spreadsheets created now
+			if shared[id] || ids[table] != "" || schema[table].Spreadsheet != "" {
+				continue
+			}
+			shared[id] = true
+			_, err = drv.Permissions.Create(id, &drive.Permission{
+				Type:         "user",
+				Role:         "writer",
+				EmailAddress: *share,
+			}).Do()
+			if err != nil {
+				return errors.Wrapf(err, "share %v", id)
+			}
+		}
+	}
+
+	fmt.Println()
+	for _, table := range database.Tables {
+		if id, ok := report.Ids[table]; ok {
+			fmt.Printf("%v=%v\n", sheetVars[table], id)
+		}
+	}
+	if len(report.Mismatches) > 0 {
+		return errors.Errorf("%d mismatches", len(report.Mismatches))
+	}
+	return nil
+}
+
+//copy stored data to another storage:
+//migrate -from sheets -to sheets -to-env .env.new [-dry-run]
+func migrateStorage(ctx context.Context, srv *sheets.Service, conf config.SheetsConfig, schema database.Schema, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", database.BackendSheets, "source backend")
+	to := fs.String("to", database.BackendSheets, "target backend")
+	toEnv := fs.String("to-env", "", "env file with SHEET_* and SHEETS_SCHEMA of the target")
+	dryRun := fs.Bool("dry-run", false, "only count what would be copied")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, backend := range []string{*from, *to} {
+		if backend != database.BackendSheets {
+			return errors.Errorf("backend %q is not implemented, the bot has no other storage than %q", backend, database.BackendSheets)
+		}
+	}
+	if *toEnv == "" {
+		return errors.New("-to-env is required")
+	}
+
+	source, err := database.NewSheetsSrv(srv,
+		conf.Users, conf.Msg, conf.Admins, conf.Banned,
+		conf.Canned, conf.History, conf.Notes, conf.Tags, conf.Broadcasts,
+		schema)
+	if err != nil {
+		return errors.Wrap(err, "source")
+	}
+	targetConf, err := config.SheetsFromFile(*toEnv)
+	if err != nil {
+		return errors.Wrap(err, "target conf")
+	}
+	targetSchema, err := database.LoadSchema(targetConf.Schema)
+	if err != nil {
+		return errors.Wrap(err, "target schema")
+	}
+	targetSrv, err := pkg.NewSheetsService(ctx, "sheets.json", targetConf)
+	if err != nil {
+		return errors.Wrap(err, "target service")
+	}
+	target, err := database.NewSheetsSrv(targetSrv,
+		targetConf.Users, targetConf.Msg, targetConf.Admins, targetConf.Banned,
+		targetConf.Canned, targetConf.History, targetConf.Notes, targetConf.Tags, targetConf.Broadcasts,
+		targetSchema)
+	if err != nil {
+		return errors.Wrap(err, "target")
+	}
+
+	counts, err := database.Migrate(source, target, *dryRun, func(table string, done, total int) {
+		fmt.Printf("%v: %v/%v\n", table, done, total)
+	})
+	for _, c := range counts {
+		fmt.Printf("%v: copied %v, already there %v\n", c.Table, c.Copied, c.Existing)
+	}
+	return err
+}
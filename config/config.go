@@ -4,6 +4,8 @@ import (
 	"flag"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -13,36 +15,183 @@ const (
 	//tg
 	token = "TG_TOKEN"
 	//google
-	sheetUsers  = "SHEET_USERS"
-	sheetMsg    = "SHEET_MSG"
-	sheetAdmins = "SHEET_ADMINS"
-	sheetBanned = "SHEET_BANNED"
+	sheetUsers      = "SHEET_USERS"
+	sheetMsg        = "SHEET_MSG"
+	sheetAdmins     = "SHEET_ADMINS"
+	sheetBanned     = "SHEET_BANNED"
+	sheetCanned     = "SHEET_CANNED"
+	sheetHistory    = "SHEET_HISTORY"
+	sheetNotes      = "SHEET_NOTES"
+	sheetTags       = "SHEET_TAGS"
+	sheetBroadcasts = "SHEET_BROADCASTS"
+	sheetsFlush     = "SHEETS_FLUSH"
+	sheetsQueue     = "SHEETS_QUEUE"
+	sheetsId        = "SHEETS_ID"
+	sheetsSchema    = "SHEETS_SCHEMA"
+	sheetsRetry     = "SHEETS_RETRIES"
+	sheetsBreak     = "SHEETS_BREAKER"
+	sheetsCool      = "SHEETS_COOLDOWN"
 	//cache
-	cacheAddr = "CACHE_ADDR"
-	keepTime  = "CACHE_KEEPTIME"
+	cacheBackend = "CACHE_BACKEND"
+	cachePath    = "CACHE_PATH"
+	cacheAddr    = "CACHE_ADDR"
+	cachePrefix  = "CACHE_PREFIX"
+	//redis
+	redisUsername         = "CACHE_USERNAME"
+	redisPassword         = "CACHE_PASSWORD"
+	redisDB               = "CACHE_DB"
+	redisSentinelMaster   = "CACHE_SENTINEL_MASTER"
+	redisSentinelPassword = "CACHE_SENTINEL_PASSWORD"
+	redisCluster          = "CACHE_CLUSTER"
+	redisTLS              = "CACHE_TLS"
+	redisTLSCA            = "CACHE_TLS_CA"
+	redisTLSCert          = "CACHE_TLS_CERT"
+	redisTLSKey           = "CACHE_TLS_KEY"
+	redisTLSSkipVerify    = "CACHE_TLS_SKIP_VERIFY"
+	//working hours
+	workTZ       = "WORK_TZ"
+	workHours    = "WORK_HOURS"
+	workDays     = "WORK_DAYS"
+	holidays     = "HOLIDAYS"
+	responseTime = "RESPONSE_TIME"
+	//feedback
+	ackWindow = "ACK_WINDOW"
+	//sla
+	slaRemind   = "SLA_REMIND"
+	slaEscalate = "SLA_ESCALATE"
+	slaInterval = "SLA_INTERVAL"
+	owners      = "OWNERS"
+	//reporting spreadsheet
+	reportSheet    = "REPORT_SHEET"
+	reportInterval = "REPORT_INTERVAL"
+	reportDays     = "REPORT_DAYS"
+	//digest to admins
+	digestTo    = "DIGEST_TO"
+	digestAt    = "DIGEST_AT"
+	digestEvery = "DIGEST_EVERY"
+	//prometheus
+	metricsAddr = "METRICS_ADDR"
+)
+
+const (
+	CacheRedis = "redis"
+	CacheBolt  = "bolt"
+
+	DigestDaily  = "day"
+	DigestWeekly = "week"
 )
 
 type (
 	Conf struct {
-		Tg     TgConfig
-		Sheets SheetsConfig
-		Redis  RedisConfig
+		Tg       TgConfig
+		Sheets   SheetsConfig
+		Cache    CacheConfig
+		Redis    RedisConfig
+		Work     WorkConfig
+		Feedback FeedbackConfig
+		SLA      SLAConfig
+		Report   ReportConfig
+		Digest   DigestConfig
+		Metrics  MetricsConfig
 	}
 
 	TgConfig struct {
 		Token string
 	}
 
+	//zero Flush, the default, writes straight to sheets, otherwise writes are batched
+	//every Flush and queued in the Queue file. Without Flush the Queue file keeps
+	//writes that failed while sheets was down. Schema is a json file with
+	//tabs and headers of the tables. Failed calls are retried Retries times,
+	//after BreakerFailures failed calls sheets is not called for BreakerCooldown
 	SheetsConfig struct {
-		Users  string
-		Msg    string
-		Admins string
-		Banned string
+		Users      string
+		Msg        string
+		Admins     string
+		Banned     string
+		Canned     string
+		History    string
+		Notes      string
+		Tags       string
+		Broadcasts string
+		Flush      time.Duration
+		Queue      string
+		Schema     string
+
+		Retries         int
+		BreakerFailures int
+		BreakerCooldown time.Duration
 	}
 
+	//Backend is CacheRedis or CacheBolt, Path is the bolt file
+	CacheConfig struct {
+		Backend string
+		Path    string
+	}
+
+	//several Addrs are sentinel or cluster nodes
 	RedisConfig struct {
-		KeepTime int64
-		Addr     string
+		Addrs            []string
+		Prefix           string
+		Username         string
+		Password         string
+		DB               int
+		MasterName       string
+		SentinelPassword string
+		Cluster          bool
+		TLS              RedisTLSConfig
+	}
+
+	RedisTLSConfig struct {
+		Enabled    bool
+		CA         string
+		Cert       string
+		Key        string
+		SkipVerify bool
+	}
+
+	//office hours, empty Days means always open, Close before Open is an
+	//overnight range that belongs to the day it starts on
+	WorkConfig struct {
+		Location     *time.Location
+		Open         time.Duration
+		Close        time.Duration
+		Days         []time.Weekday
+		Holidays     []string
+		ResponseTime string
+	}
+
+	//acknowledgements to a user are coalesced within AckWindow
+	FeedbackConfig struct {
+		AckWindow time.Duration
+	}
+
+	//empty Sheet disables the report, Days is the length of daily stats
+	ReportConfig struct {
+		Sheet    string
+		Interval time.Duration
+		Days     int
+	}
+
+	//summary sent to the To admins every day or on mondays at At after
+	//midnight of the work timezone, empty To disables it
+	DigestConfig struct {
+		To    []string
+		At    time.Duration
+		Every string
+	}
+
+	//empty Addr disables the /metrics endpoint
+	MetricsConfig struct {
+		Addr string
+	}
+
+	//zero Remind and Escalate disable the checks
+	SLAConfig struct {
+		Remind   time.Duration
+		Escalate time.Duration
+		Interval time.Duration
+		Owners   []string
 	}
 )
 
@@ -62,24 +211,329 @@ func envVar(test bool) (*Conf, error) {
 		}
 	}
 
-	keepTimeInt, err := strconv.Atoi(os.Getenv(keepTime))
+	work, err := workConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "workConfig")
+	}
+
+	ackWindowSec := 60
+	if v := os.Getenv(ackWindow); v != "" {
+		ackWindowSec, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "ackWindow")
+		}
+	}
+
+	sla, err := slaConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "slaConfig")
+	}
+
+	redisConf, err := redisConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "redisConfig")
+	}
+
+	sheetsConf, err := sheetsConfig(os.Getenv)
 	if err != nil {
-		return nil, errors.Wrap(err, "keepTime")
+		return nil, errors.Wrap(err, "sheetsConfig")
+	}
+
+	report, err := reportConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "reportConfig")
+	}
+
+	digest, err := digestConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "digestConfig")
 	}
 
 	return &Conf{
 		Tg: TgConfig{
 			Token: os.Getenv(token),
 		},
-		Sheets: SheetsConfig{
-			Users:  os.Getenv(sheetUsers),
-			Msg:    os.Getenv(sheetMsg),
-			Admins: os.Getenv(sheetAdmins),
-			Banned: os.Getenv(sheetBanned),
+		Sheets: sheetsConf,
+		Cache: cacheConfig(),
+		Redis: redisConf,
+		Work: work,
+		Feedback: FeedbackConfig{
+			AckWindow: time.Duration(ackWindowSec) * time.Second,
 		},
-		Redis: RedisConfig{
-			KeepTime: int64(keepTimeInt),
-			Addr:     os.Getenv(cacheAddr),
+		SLA:    sla,
+		Report: report,
+		Digest: digest,
+		Metrics: MetricsConfig{
+			Addr: os.Getenv(metricsAddr),
 		},
 	}, nil
 }
+
+func digestConfig() (DigestConfig, error) {
+	conf := DigestConfig{
+		To:    list(os.Getenv(digestTo)),
+		At:    9 * time.Hour,
+		Every: os.Getenv(digestEvery),
+	}
+	if conf.Every == "" {
+		conf.Every = DigestDaily
+	}
+	if conf.Every != DigestDaily && conf.Every != DigestWeekly {
+		return conf, errors.Errorf("%v: expected %v or %v", digestEvery, DigestDaily, DigestWeekly)
+	}
+	if v := os.Getenv(digestAt); v != "" {
+		at, err := time.Parse("15:04", v)
+		if err != nil {
+			return conf, errors.Wrap(err, digestAt)
+		}
+		conf.At = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	}
+	return conf, nil
+}
+
+func reportConfig() (ReportConfig, error) {
+	conf := ReportConfig{Sheet: os.Getenv(reportSheet), Days: 30}
+	var err error
+	conf.Interval, err = minutes(reportInterval, 60)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = time.Hour
+	}
+	if v := os.Getenv(reportDays); v != "" {
+		conf.Days, err = strconv.Atoi(v)
+		if err != nil {
+			return conf, errors.Wrap(err, reportDays)
+		}
+	}
+	if conf.Days <= 0 {
+		conf.Days = 1
+	}
+	return conf, nil
+}
+
+//sheets settings of another deployment, used as the target of migrate
+func SheetsFromFile(path string) (SheetsConfig, error) {
+	env, err := godotenv.Read(path)
+	if err != nil {
+		return SheetsConfig{}, errors.Wrap(err, "Read")
+	}
+	return sheetsConfig(func(key string) string { return env[key] })
+}
+
+func sheetsConfig(getenv func(string) string) (SheetsConfig, error) {
+	nums := map[string]int{sheetsFlush: 0, sheetsRetry: 5, sheetsBreak: 5, sheetsCool: 30}
+	for key := range nums {
+		if v := getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return SheetsConfig{}, errors.Wrap(err, key)
+			}
+			nums[key] = n
+		}
+	}
+	queue := getenv(sheetsQueue)
+	if queue == "" {
+		queue = "sheets-queue.json"
+	}
+	//one spreadsheet for every table that has no own
+	sheet := func(key string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+		return getenv(sheetsId)
+	}
+	return SheetsConfig{
+		Users:      sheet(sheetUsers),
+		Msg:        sheet(sheetMsg),
+		Admins:     sheet(sheetAdmins),
+		Banned:     sheet(sheetBanned),
+		Canned:     sheet(sheetCanned),
+		History:    sheet(sheetHistory),
+		Notes:      sheet(sheetNotes),
+		Tags:       sheet(sheetTags),
+		Broadcasts: sheet(sheetBroadcasts),
+		Flush:      time.Duration(nums[sheetsFlush]) * time.Second,
+		Queue:      queue,
+		Schema:     getenv(sheetsSchema),
+
+		Retries:         nums[sheetsRetry],
+		BreakerFailures: nums[sheetsBreak],
+		BreakerCooldown: time.Duration(nums[sheetsCool]) * time.Second,
+	}, nil
+}
+
+func slaConfig() (SLAConfig, error) {
+	conf := SLAConfig{Owners: list(os.Getenv(owners))}
+	var err error
+	conf.Remind, err = minutes(slaRemind, 0)
+	if err != nil {
+		return conf, err
+	}
+	conf.Escalate, err = minutes(slaEscalate, 0)
+	if err != nil {
+		return conf, err
+	}
+	conf.Interval, err = minutes(slaInterval, 1)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = time.Minute
+	}
+	return conf, nil
+}
+
+//duration in minutes from env var
+func minutes(key string, def int) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return time.Duration(def) * time.Minute, nil
+	}
+	m, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.Wrap(err, key)
+	}
+	return time.Duration(m) * time.Minute, nil
+}
+
+//comma separated values, leading @ of nicknames is dropped
+func list(s string) []string {
+	out := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.Trim(strings.TrimSpace(v), "@")
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func cacheConfig() CacheConfig {
+	conf := CacheConfig{
+		Backend: os.Getenv(cacheBackend),
+		Path:    os.Getenv(cachePath),
+	}
+	if conf.Backend == "" {
+		conf.Backend = CacheRedis
+	}
+	if conf.Path == "" {
+		conf.Path = "cache.db"
+	}
+	return conf
+}
+
+func redisConfig() (RedisConfig, error) {
+	conf := RedisConfig{
+		Addrs:            list(os.Getenv(cacheAddr)),
+		Prefix:           os.Getenv(cachePrefix),
+		Username:         os.Getenv(redisUsername),
+		Password:         os.Getenv(redisPassword),
+		MasterName:       os.Getenv(redisSentinelMaster),
+		SentinelPassword: os.Getenv(redisSentinelPassword),
+		Cluster:          os.Getenv(redisCluster) == "true",
+		TLS: RedisTLSConfig{
+			Enabled:    os.Getenv(redisTLS) == "true",
+			CA:         os.Getenv(redisTLSCA),
+			Cert:       os.Getenv(redisTLSCert),
+			Key:        os.Getenv(redisTLSKey),
+			SkipVerify: os.Getenv(redisTLSSkipVerify) == "true",
+		},
+	}
+	if db := os.Getenv(redisDB); db != "" {
+		var err error
+		conf.DB, err = strconv.Atoi(db)
+		if err != nil {
+			return conf, errors.Wrap(err, redisDB)
+		}
+	}
+	if conf.Cluster && conf.MasterName != "" {
+		return conf, errors.Errorf("%v and %v can't be used together", redisCluster, redisSentinelMaster)
+	}
+	return conf, nil
+}
+
+func workConfig() (WorkConfig, error) {
+	conf := WorkConfig{
+		Location:     time.Local,
+		ResponseTime: os.Getenv(responseTime),
+	}
+	if tz := os.Getenv(workTZ); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return conf, errors.Wrap(err, "LoadLocation")
+		}
+		conf.Location = loc
+	}
+
+	hours := os.Getenv(workHours)
+	if hours == "" {
+		return conf, nil
+	}
+	bounds := strings.Split(hours, "-")
+	if len(bounds) != 2 {
+		return conf, errors.Errorf("%v: expected hh:mm-hh:mm", workHours)
+	}
+	open, err := time.Parse("15:04", strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return conf, errors.Wrap(err, "open")
+	}
+	closing, err := time.Parse("15:04", strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return conf, errors.Wrap(err, "close")
+	}
+	conf.Open = time.Duration(open.Hour())*time.Hour + time.Duration(open.Minute())*time.Minute
+	conf.Close = time.Duration(closing.Hour())*time.Hour + time.Duration(closing.Minute())*time.Minute
+	//close before open is an overnight range
+	if conf.Open == conf.Close {
+		return conf, errors.Errorf("%v: open and close are the same", workHours)
+	}
+
+	days := os.Getenv(workDays)
+	if days == "" {
+		days = "1-5"
+	}
+	conf.Days, err = weekdays(days)
+	if err != nil {
+		return conf, errors.Wrap(err, "weekdays")
+	}
+
+	for _, day := range strings.Split(os.Getenv(holidays), ",") {
+		day = strings.TrimSpace(day)
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return conf, errors.Wrap(err, "holiday")
+		}
+		conf.Holidays = append(conf.Holidays, day)
+	}
+	return conf, nil
+}
+
+//"1-5" or "1,3,5", monday is 1 and sunday is 7
+func weekdays(s string) ([]time.Weekday, error) {
+	out := make([]time.Weekday, 0, 7)
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "Atoi")
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, errors.Wrap(err, "Atoi")
+			}
+		}
+		if from < 1 || to > 7 || from > to {
+			return nil, errors.Errorf("bad day range %v", part)
+		}
+		for d := from; d <= to; d++ {
+			out = append(out, time.Weekday(d%7))
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/sheets/v4"
+)
+
+//what InitSheets found and did
+type InitReport struct {
+	//spreadsheet of every table
+	Ids        map[string]string
+	Created    []string
+	Mismatches []string
+}
+
+//values the bot writes, checked by sheets so that manual edits stand out
+var validations = map[string]map[string]*sheets.DataValidationRule{
+	TableUsers:      {"id": positiveRule(), "created": positiveRule(), "blocked": positiveRule()},
+	TableMsg:        {"id": positiveRule(), "since": positiveRule()},
+	TableAdmins:     {"chat_id": positiveRule()},
+	TableHistory:    {"user_id": positiveRule(), "msg_id": positiveRule(), "direction": listRule(DirIn, DirOut, DirBroadcast), "time": positiveRule()},
+	TableNotes:      {"user_id": positiveRule(), "time": positiveRule()},
+	TableTags:       {"user_id": positiveRule()},
+	TableBroadcasts: {"time": positiveRule()},
+}
+
+func positiveRule() *sheets.DataValidationRule {
+	return &sheets.DataValidationRule{
+		Condition: &sheets.BooleanCondition{
+			Type:   "NUMBER_GREATER",
+			Values: []*sheets.ConditionValue{{UserEnteredValue: "0"}},
+		},
+	}
+}
+
+func listRule(values ...string) *sheets.DataValidationRule {
+	cond := &sheets.BooleanCondition{Type: "ONE_OF_LIST"}
+	for _, v := range values {
+		cond.Values = append(cond.Values, &sheets.ConditionValue{UserEnteredValue: v})
+	}
+	return &sheets.DataValidationRule{Condition: cond, ShowCustomUi: true}
+}
+
+//create spreadsheets, tabs and header rows that are missing, freeze the
+//header and set data validation. Every table without an id gets its own
+//spreadsheet named "<title> <table>". With check nothing is changed and
+//everything missing is reported as a mismatch.
+func InitSheets(srv *sheets.Service, ids map[string]string, schema Schema, title string, check bool) (*InitReport, error) {
+	sheetIds, tabs, err := placement(ids, schema)
+	if err != nil {
+		return nil, err
+	}
+	report := &InitReport{Ids: make(map[string]string)}
+	metas := make(map[string]*sheets.Spreadsheet)
+	for _, name := range Tables {
+		layout := schema[name]
+		tab := tabs[name]
+		id := sheetIds[name]
+
+		if id == "" {
+			if check {
+				report.Mismatches = append(report.Mismatches, fmt.Sprintf("%v: no spreadsheet", name))
+				continue
+			}
+			created, err := srv.Spreadsheets.Create(&sheets.Spreadsheet{
+				Properties: &sheets.SpreadsheetProperties{Title: title + " " + name},
+				Sheets:     []*sheets.Sheet{{Properties: &sheets.SheetProperties{Title: tab}}},
+			}).Do()
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v: Create", name)
+			}
+			id = created.SpreadsheetId
+			metas[id] = created
+			report.Created = append(report.Created, fmt.Sprintf("%v: spreadsheet %v", name, id))
+		}
+		report.Ids[name] = id
+
+		meta, ok := metas[id]
+		if !ok {
+			var err error
+			meta, err = srv.Spreadsheets.Get(id).Fields("spreadsheetId,sheets.properties").Do()
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v: Get", name)
+			}
+			metas[id] = meta
+		}
+		props := tabProperties(meta, tab)
+		if props == nil {
+			if check {
+				report.Mismatches = append(report.Mismatches, fmt.Sprintf("%v: no tab %q", name, tab))
+				continue
+			}
+			rsp, err := srv.Spreadsheets.BatchUpdate(id, &sheets.BatchUpdateSpreadsheetRequest{
+				Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{
+					Properties: &sheets.SheetProperties{Title: tab},
+				}}},
+			}).Do()
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v: AddSheet", name)
+			}
+			props = rsp.Replies[0].AddSheet.Properties
+			meta.Sheets = append(meta.Sheets, &sheets.Sheet{Properties: props})
+			report.Created = append(report.Created, fmt.Sprintf("%v: tab %q", name, tab))
+		}
+
+		t := &table{name: name, id: id, tab: tab, sheetId: props.SheetId}
+		err := initHeader(srv, t, layout, props, check, report)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v: header", name)
+		}
+	}
+	return report, nil
+}
+
+//write missing headers, then freeze and validate the columns
+func initHeader(srv *sheets.Service, t *table, layout Layout, props *sheets.SheetProperties, check bool, report *InitReport) error {
+	rsp, err := srv.Spreadsheets.Values.Get(t.id, t.quoted()+"!1:1").Do()
+	if err != nil {
+		return errors.Wrap(err, "Get")
+	}
+	var header []interface{}
+	if len(rsp.Values) > 0 {
+		header = rsp.Values[0]
+	}
+	names := layout.Headers(t.name)
+	missing := make([]string, 0)
+	required := 0
+	for i, name := range names {
+		if headerColumn(header, name) < 0 {
+			missing = append(missing, name)
+			if !optional[t.name][Fields[t.name][i]] {
+				required++
+			}
+		}
+	}
+
+	used := 0
+	for i := range header {
+		if cell(header, i) != "" {
+			used = i + 1
+		}
+	}
+	switch {
+	case len(missing) == 0:
+	//the legacy layout keeps data from the first row
+	case used > 0 && required > 0 && len(layout.Columns) == 0:
+		report.Mismatches = append(report.Mismatches,
+			fmt.Sprintf("%v: first row is data, not a header, the table is used without one", t.name))
+		return nil
+	case check:
+		report.Mismatches = append(report.Mismatches,
+			fmt.Sprintf("%v: no columns %v", t.name, strings.Join(missing, ", ")))
+		return nil
+	default:
+		values := make([]interface{}, 0, len(missing))
+		for _, name := range missing {
+			values = append(values, name)
+		}
+		start := column(used + 1)
+		r := fmt.Sprintf("%v!%v1:%v1", t.quoted(), start, column(used+len(values)))
+		_, err = srv.Spreadsheets.Values.
+			Update(t.id, r, &sheets.ValueRange{MajorDimension: "ROWS", Values: [][]interface{}{values}}).
+			ValueInputOption("RAW").
+			Do()
+		if err != nil {
+			return errors.Wrap(err, "Update")
+		}
+		header = append(header[:used], values...)
+		report.Created = append(report.Created,
+			fmt.Sprintf("%v: columns %v", t.name, strings.Join(missing, ", ")))
+	}
+
+	frozen := props.GridProperties != nil && props.GridProperties.FrozenRowCount == 1
+	if check {
+		if !frozen {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("%v: header row is not frozen", t.name))
+		}
+		return nil
+	}
+
+	requests := []*sheets.Request{{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+		Properties: &sheets.SheetProperties{
+			SheetId:        t.sheetId,
+			GridProperties: &sheets.GridProperties{FrozenRowCount: 1},
+		},
+		Fields: "gridProperties.frozenRowCount",
+	}}}
+	cols, _ := headerColumns(header, t.name, names)
+	for i, field := range Fields[t.name] {
+		rule, ok := validations[t.name][field]
+		if !ok || cols[i] < 0 {
+			continue
+		}
+		requests = append(requests, &sheets.Request{SetDataValidation: &sheets.SetDataValidationRequest{
+			Range: &sheets.GridRange{
+				SheetId:          t.sheetId,
+				StartRowIndex:    1,
+				StartColumnIndex: int64(cols[i]),
+				EndColumnIndex:   int64(cols[i] + 1),
+			},
+			Rule: rule,
+		}})
+	}
+	_, err = srv.Spreadsheets.BatchUpdate(t.id, &sheets.BatchUpdateSpreadsheetRequest{Requests: requests}).Do()
+	if err != nil {
+		return errors.Wrap(err, "BatchUpdate")
+	}
+	return nil
+}
+
+func tabProperties(meta *sheets.Spreadsheet, tab string) *sheets.SheetProperties {
+	for _, sheet := range meta.Sheets {
+		if sheet.Properties != nil && sheet.Properties.Title == tab {
+			return sheet.Properties
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestInitSheetsCheck(t *testing.T) {
+	headers := map[string][]interface{}{
+		//header without an optional column
+		TableUsers: {"id", "name", "nick", "region", "created"},
+		//legacy data from the first row
+		TableMsg: {"42", "1,2", "1600000000"},
+		//complete header, frozen rows are not served by the fake
+		TableAdmins: {"nick", "chat_id"},
+		TableBanned: nil,
+	}
+	srv := fakeSpreadsheet(t, headers)
+	ids := map[string]string{}
+	for _, name := range Tables {
+		ids[name] = "doc"
+	}
+	delete(ids, TableTags)
+
+	report, err := InitSheets(srv, ids, Schema{}, "test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 {
+		t.Errorf("check created %v", report.Created)
+	}
+	want := []string{
+		"admins: header row is not frozen",
+		"banned: no columns nick",
+		"broadcasts: no tab \"broadcasts\"",
+		"canned: no tab \"canned\"",
+		"history: no tab \"history\"",
+		"msg: first row is data, not a header, the table is used without one",
+		"notes: no tab \"notes\"",
+		"tags: no spreadsheet",
+		"users: no columns blocked",
+	}
+	got := append([]string(nil), report.Mismatches...)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatches\n%q\nwant\n%q", got, want)
+	}
+}
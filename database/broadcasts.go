@@ -0,0 +1,71 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//result of one /all
+type Broadcast struct {
+	Time    time.Time
+	Admin   string
+	Sent    int
+	Blocked int
+	Failed  int
+}
+
+//broadcasts: unix time, admin, sent, blocked, failed. Without the table
+//results are not kept
+func (s sheetsSrv) SaveBroadcast(b Broadcast) error {
+	if s.broadcasts.id == "" {
+		return nil
+	}
+	_, err := s.appendRows(s.broadcasts, broadcastRow(b))
+	if err != nil {
+		return errors.Wrap(err, "Append")
+	}
+	return nil
+}
+
+//broadcasts after since, oldest first
+func (s sheetsSrv) GetBroadcasts(since time.Time) ([]Broadcast, error) {
+	out := make([]Broadcast, 0)
+	if s.broadcasts.id == "" {
+		return out, nil
+	}
+	rows, err := s.rows(s.broadcasts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	for _, row := range rows {
+		b, ok := parseBroadcast(row)
+		if ok && b.Time.After(since) {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func broadcastRow(b Broadcast) []interface{} {
+	return []interface{}{b.Time.Unix(), b.Admin, b.Sent, b.Blocked, b.Failed}
+}
+
+func parseBroadcast(row []interface{}) (Broadcast, bool) {
+	ts, err := strconv.ParseInt(cell(row, 0), 10, 64)
+	if err != nil {
+		return Broadcast{}, false
+	}
+	count := func(i int) int {
+		n, _ := strconv.Atoi(cell(row, i))
+		return n
+	}
+	return Broadcast{
+		Time:    time.Unix(ts, 0),
+		Admin:   cell(row, 1),
+		Sent:    count(2),
+		Blocked: count(3),
+		Failed:  count(4),
+	}, true
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"github.com/pkg/errors"
+)
+
+//canned replies: name, text. Without the table there are none and
+//changes return ErrDisabled
+func (s sheetsSrv) LoadCanned() (map[string]string, error) {
+	out := make(map[string]string)
+	if s.canned.id == "" {
+		return out, nil
+	}
+	rows, err := s.rows(s.canned)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	for _, row := range rows {
+		name := cell(row, 0)
+		if name == "" {
+			continue
+		}
+		out[name] = cell(row, 1)
+	}
+	return out, nil
+}
+
+func (s sheetsSrv) SaveCanned(name, text string) error {
+	if s.canned.id == "" {
+		return ErrDisabled
+	}
+	row, err := s.cannedRow(name)
+	if err != nil {
+		return errors.Wrap(err, "cannedRow")
+	}
+	if row == 0 {
+		_, err = s.appendRows(s.canned, []interface{}{name, text})
+		if err != nil {
+			return errors.Wrap(err, "Append")
+		}
+		return nil
+	}
+	err = s.updateRow(s.canned, row, []interface{}{name, text})
+	if err != nil {
+		return errors.Wrap(err, "Update")
+	}
+	return nil
+}
+
+func (s sheetsSrv) DeleteCanned(name string) error {
+	if s.canned.id == "" {
+		return ErrDisabled
+	}
+	row, err := s.cannedRow(name)
+	if err != nil {
+		return errors.Wrap(err, "cannedRow")
+	}
+	if row == 0 {
+		return ErrNoRows
+	}
+	err = s.updateRow(s.canned, row, s.canned.blank())
+	if err != nil {
+		return errors.Wrap(err, "Update")
+	}
+	return nil
+}
+
+//row number of a canned reply, 0 if there is none
+func (s sheetsSrv) cannedRow(name string) (int, error) {
+	rsp, err := s.srv.Spreadsheets.Values.Get(s.canned.id, s.canned.keys()).Do()
+	if err != nil {
+		return 0, errors.Wrap(err, "Get")
+	}
+	rows := newRowIndex(rsp.Values, 0, keyString, s.canned.header).Find(name)
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[0], nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DirIn  = "in"
+	DirOut = "out"
+	//copy of an /all message, not a reply to the user
+	DirBroadcast = "broadcast"
+)
+
+//message of a conversation, inbound from the user, outbound from an admin
+//or a broadcast
+type Message struct {
+	UserId    int64
+	MsgId     int
+	Direction string
+	Admin     string
+	Type      string
+	FileId    string
+	Text      string
+	Time      time.Time
+}
+
+//history: user id, message id, direction, admin, media type, file id,
+//text, unix time. Without the table messages are not kept
+func (s sheetsSrv) SaveHistory(msgs ...Message) error {
+	if len(msgs) == 0 || s.history.id == "" {
+		return nil
+	}
+	rows := make([][]interface{}, 0, len(msgs))
+	for _, m := range msgs {
+		rows = append(rows, historyRow(m))
+	}
+	_, err := s.appendRows(s.history, rows...)
+	if err != nil {
+		return errors.Wrap(err, "Append")
+	}
+	return nil
+}
+
+//messages of a user, oldest first
+func (s sheetsSrv) GetHistory(userId int64) ([]Message, error) {
+	all, err := s.GetAllHistory()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetAllHistory")
+	}
+	return userHistory(all, userId), nil
+}
+
+func (s sheetsSrv) GetAllHistory() ([]Message, error) {
+	if s.history.id == "" {
+		return []Message{}, nil
+	}
+	rows, err := s.rows(s.history)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	return parseHistory(rows), nil
+}
+
+func historyRow(m Message) []interface{} {
+	return []interface{}{
+		m.UserId, m.MsgId, m.Direction, m.Admin, m.Type, m.FileId, m.Text, m.Time.Unix(),
+	}
+}
+
+func parseHistory(rows [][]interface{}) []Message {
+	out := make([]Message, 0, len(rows))
+	for _, row := range rows {
+		userId, ok := parseId(cell(row, 0))
+		if !ok {
+			continue
+		}
+		msgId, _ := strconv.Atoi(cell(row, 1))
+		ts, _ := strconv.ParseInt(cell(row, 7), 10, 64)
+		out = append(out, Message{
+			UserId:    userId,
+			MsgId:     msgId,
+			Direction: cell(row, 2),
+			Admin:     cell(row, 3),
+			Type:      cell(row, 4),
+			FileId:    cell(row, 5),
+			Text:      cell(row, 6),
+			Time:      time.Unix(ts, 0),
+		})
+	}
+	return out
+}
+
+func userHistory(all []Message, userId int64) []Message {
+	out := make([]Message, 0)
+	for _, m := range all {
+		if m.UserId == userId {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//rows appended in one call
+const migrateBatch = 500
+
+//backends Migrate can read and write. There is no sql storage, so there is
+//no sql target and no mapping of ids to it
+const BackendSheets = "sheets"
+
+//counts of one table moved by Migrate
+type MigrateCount struct {
+	Table  string
+	Copied int
+	//already in the target, re-runs copy only what is missing
+	Existing int
+}
+
+//copy every table from one sheets storage to another. Telegram ids and nicks are the keys in both, so rows
+//already in the target are skipped and a re-run continues where a failed
+//one stopped. With dryRun nothing is written.
+func Migrate(from, to *sheetsSrv, dryRun bool, progress func(table string, done, total int)) ([]MigrateCount, error) {
+	steps := []struct {
+		table *table
+		rows  func(s *sheetsSrv) ([]string, [][]interface{}, error)
+	}{
+		{from.users, contactRows},
+		{from.msg, pendingRows},
+		{from.history, historyRows},
+		{from.admins, adminRows},
+		{from.banned, bannedRows},
+		{from.canned, cannedRows},
+		{from.notes, noteRows},
+		{from.tags, tagRows},
+		{from.broadcasts, broadcastRows},
+	}
+	out := make([]MigrateCount, 0, len(steps))
+	for _, step := range steps {
+		name := step.table.name
+		//optional tables that are not configured
+		if step.table.id == "" {
+			continue
+		}
+		keys, rows, err := step.rows(from)
+		if err != nil {
+			return out, errors.Wrapf(err, "%v: read source", name)
+		}
+		target := to.table(name)
+		if target.id == "" {
+			return out, errors.Errorf("%v: no target spreadsheet", name)
+		}
+		existing, _, err := step.rows(to)
+		if err != nil {
+			return out, errors.Wrapf(err, "%v: read target", name)
+		}
+		seen := make(map[string]bool, len(existing))
+		for _, k := range existing {
+			seen[k] = true
+		}
+
+		count := MigrateCount{Table: name}
+		batch := make([][]interface{}, 0, migrateBatch)
+		flush := func() error {
+			if len(batch) == 0 || dryRun {
+				batch = batch[:0]
+				return nil
+			}
+			_, err := to.appendRows(target, batch...)
+			batch = batch[:0]
+			return err
+		}
+		for i, row := range rows {
+			if seen[keys[i]] {
+				count.Existing++
+			} else {
+				seen[keys[i]] = true
+				batch = append(batch, row)
+				count.Copied++
+			}
+			if len(batch) == migrateBatch {
+				if err := flush(); err != nil {
+					return out, errors.Wrapf(err, "%v: append", name)
+				}
+			}
+			if progress != nil && ((i+1)%migrateBatch == 0 || i+1 == len(rows)) {
+				progress(name, i+1, len(rows))
+			}
+		}
+		if err := flush(); err != nil {
+			return out, errors.Wrapf(err, "%v: append", name)
+		}
+		out = append(out, count)
+	}
+	return out, nil
+}
+
+func (s *sheetsSrv) table(name string) *table {
+	switch name {
+	case TableUsers:
+		return s.users
+	case TableMsg:
+		return s.msg
+	case TableAdmins:
+		return s.admins
+	case TableBanned:
+		return s.banned
+	case TableCanned:
+		return s.canned
+	case TableHistory:
+		return s.history
+	case TableNotes:
+		return s.notes
+	case TableTags:
+		return s.tags
+	case TableBroadcasts:
+		return s.broadcasts
+	}
+	return nil
+}
+
+//rows to copy with their keys, every row is written as it was read
+func contactRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	contacts, err := s.GetContacts()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(contacts))
+	rows := make([][]interface{}, 0, len(contacts))
+	for _, c := range contacts {
+		var created interface{} = ""
+		if !c.Created.IsZero() {
+			created = c.Created.Unix()
+		}
+		var blocked interface{} = ""
+		if !c.Blocked.IsZero() {
+			blocked = c.Blocked.Unix()
+		}
+		keys = append(keys, strconv.FormatInt(c.Id, 10))
+		rows = append(rows, []interface{}{c.Id, c.Name, c.Nick, c.Region, created, blocked})
+	}
+	return keys, rows, nil
+}
+
+func pendingRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	pending, err := s.GetPending()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(pending))
+	rows := make([][]interface{}, 0, len(pending))
+	for _, p := range pending {
+		if len(p.MsgIds) == 0 {
+			continue
+		}
+		//stored newest first
+		ids := ""
+		for i := len(p.MsgIds) - 1; i >= 0; i-- {
+			if ids != "" {
+				ids += ","
+			}
+			ids += strconv.Itoa(p.MsgIds[i])
+		}
+		//rows without the time stay without it instead of 1 Jan 1 in unix time
+		var since interface{} = ""
+		if !p.Since.IsZero() {
+			since = p.Since.Unix()
+		}
+		keys = append(keys, strconv.FormatInt(p.Id, 10))
+		rows = append(rows, []interface{}{p.Id, ids, since})
+	}
+	return keys, rows, nil
+}
+
+func historyRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	msgs, err := s.GetAllHistory()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(msgs))
+	rows := make([][]interface{}, 0, len(msgs))
+	for _, m := range msgs {
+		keys = append(keys, fmt.Sprintf("%v/%v/%v/%v", m.UserId, m.MsgId, m.Direction, m.Time.Unix()))
+		rows = append(rows, historyRow(m))
+	}
+	return keys, rows, nil
+}
+
+func adminRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	admins, err := s.LoadAdmins()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(admins))
+	rows := make([][]interface{}, 0, len(admins))
+	for nick, a := range admins {
+		var chatId interface{} = ""
+		if a.ChatId != 0 {
+			chatId = a.ChatId
+		}
+		keys = append(keys, nick)
+		rows = append(rows, []interface{}{nick, chatId})
+	}
+	return keys, rows, nil
+}
+
+func bannedRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	banned, err := s.LoadBanned()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(banned))
+	rows := make([][]interface{}, 0, len(banned))
+	for nick := range banned {
+		keys = append(keys, nick)
+		rows = append(rows, []interface{}{nick})
+	}
+	return keys, rows, nil
+}
+
+func cannedRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	canned, err := s.LoadCanned()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(canned))
+	rows := make([][]interface{}, 0, len(canned))
+	for name, text := range canned {
+		keys = append(keys, name)
+		rows = append(rows, []interface{}{name, text})
+	}
+	return keys, rows, nil
+}
+
+//notes of every user, GetNotes reads one
+func noteRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	values, err := s.rows(s.notes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(values))
+	rows := make([][]interface{}, 0, len(values))
+	for _, row := range values {
+		id, ok := parseId(cell(row, 0))
+		if !ok {
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%v/%v/%v", id, cell(row, 1), cell(row, 3)))
+		rows = append(rows, []interface{}{id, cell(row, 1), cell(row, 2), cell(row, 3)})
+	}
+	return keys, rows, nil
+}
+
+func tagRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	tags, err := s.GetTags()
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(tags))
+	rows := make([][]interface{}, 0, len(tags))
+	for id, userTags := range tags {
+		for _, tag := range userTags {
+			keys = append(keys, fmt.Sprintf("%v/%v", id, tag))
+			rows = append(rows, []interface{}{id, tag})
+		}
+	}
+	return keys, rows, nil
+}
+
+func broadcastRows(s *sheetsSrv) ([]string, [][]interface{}, error) {
+	broadcasts, err := s.GetBroadcasts(time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(broadcasts))
+	rows := make([][]interface{}, 0, len(broadcasts))
+	for _, b := range broadcasts {
+		keys = append(keys, fmt.Sprintf("%v/%v", b.Time.Unix(), b.Admin))
+		rows = append(rows, broadcastRow(b))
+	}
+	return keys, rows, nil
+}
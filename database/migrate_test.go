@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+//sheets api answering every read with rows
+func fakeValues(t *testing.T, rows [][]interface{}) *sheets.Service {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sheets.ValueRange{Values: rows})
+	}))
+	t.Cleanup(ts.Close)
+	srv, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(ts.URL+"/"),
+		option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestPendingRows(t *testing.T) {
+	srv := fakeValues(t, [][]interface{}{
+		{"1", "5", ""},
+		{"2", "7,6", "1600000000"},
+	})
+	s := &sheetsSrv{srv: srv, msg: &table{name: TableMsg, id: "doc", tab: defaultTab, cols: []int{0, 1, 2}, width: 3}}
+
+	keys, rows, err := pendingRows(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || len(rows) != 2 {
+		t.Fatalf("keys = %v, rows = %v, want 2", keys, rows)
+	}
+	//a row without the time is copied without it
+	if rows[0][2] != "" {
+		t.Errorf("since of a row without time = %v, want empty", rows[0][2])
+	}
+	if rows[1][1] != "7,6" || rows[1][2] != int64(1600000000) {
+		t.Errorf("row = %v, want ids 7,6 since 1600000000", rows[1])
+	}
+}
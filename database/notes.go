@@ -0,0 +1,119 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//private admin note about a user
+type Note struct {
+	UserId int64
+	Admin  string
+	Text   string
+	Time   time.Time
+}
+
+//notes: user id, admin, text, unix time
+func (s sheetsSrv) AddNote(n Note) error {
+	if s.notes.id == "" {
+		return ErrDisabled
+	}
+	_, err := s.appendRows(s.notes, []interface{}{n.UserId, n.Admin, n.Text, n.Time.Unix()})
+	if err != nil {
+		return errors.Wrap(err, "Append")
+	}
+	return nil
+}
+
+//notes of a user, oldest first
+func (s sheetsSrv) GetNotes(userId int64) ([]Note, error) {
+	if s.notes.id == "" {
+		return []Note{}, nil
+	}
+	rows, err := s.rows(s.notes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	out := make([]Note, 0)
+	for _, row := range rows {
+		if id, ok := parseId(cell(row, 0)); !ok || id != userId {
+			continue
+		}
+		ts, _ := strconv.ParseInt(cell(row, 3), 10, 64)
+		out = append(out, Note{
+			UserId: userId,
+			Admin:  cell(row, 1),
+			Text:   cell(row, 2),
+			Time:   time.Unix(ts, 0),
+		})
+	}
+	return out, nil
+}
+
+//tags: user id, tag
+func (s sheetsSrv) AddTag(userId int64, tag string) error {
+	if s.tags.id == "" {
+		return ErrDisabled
+	}
+	tags, err := s.GetTags()
+	if err != nil {
+		return errors.Wrap(err, "GetTags")
+	}
+	for _, t := range tags[userId] {
+		if t == tag {
+			return nil
+		}
+	}
+	_, err = s.appendRows(s.tags, []interface{}{userId, tag})
+	if err != nil {
+		return errors.Wrap(err, "Append")
+	}
+	return nil
+}
+
+func (s sheetsSrv) RemoveTag(userId int64, tag string) error {
+	if s.tags.id == "" {
+		return ErrDisabled
+	}
+	rows, err := s.rows(s.tags)
+	if err != nil {
+		return errors.Wrap(err, "Get")
+	}
+	for i, row := range rows {
+		if id, ok := parseId(cell(row, 0)); !ok || id != userId || cell(row, 1) != tag {
+			continue
+		}
+		err = s.updateRow(s.tags, i+1, s.tags.blank())
+		if err != nil {
+			return errors.Wrap(err, "Update")
+		}
+	}
+	return nil
+}
+
+//tags of every user
+func (s sheetsSrv) GetTags() (map[int64][]string, error) {
+	if s.tags.id == "" {
+		return map[int64][]string{}, nil
+	}
+	rows, err := s.rows(s.tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	out := make(map[int64][]string)
+	for _, row := range rows {
+		id, ok := parseId(cell(row, 0))
+		if !ok {
+			continue
+		}
+		tag := strings.TrimSpace(cell(row, 1))
+		if tag == "" {
+			continue
+		}
+		out[id] = append(out[id], tag)
+	}
+	return out, nil
+}
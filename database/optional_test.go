@@ -0,0 +1,45 @@
+package database
+
+import (
+	"testing"
+)
+
+//optional tables without a spreadsheet never call sheets, srv is nil here
+func TestUnconfiguredTables(t *testing.T) {
+	s := sheetsSrv{history: &table{}, notes: &table{}, tags: &table{}, canned: &table{}, broadcasts: &table{}}
+	writes := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"SaveHistory", s.SaveHistory(Message{UserId: 1}), nil},
+		{"SaveBroadcast", s.SaveBroadcast(Broadcast{}), nil},
+		{"AddNote", s.AddNote(Note{UserId: 1}), ErrDisabled},
+		{"AddTag", s.AddTag(1, "vip"), ErrDisabled},
+		{"RemoveTag", s.RemoveTag(1, "vip"), ErrDisabled},
+		{"SaveCanned", s.SaveCanned("hi", "text"), ErrDisabled},
+		{"DeleteCanned", s.DeleteCanned("hi"), ErrDisabled},
+	}
+	for _, tt := range writes {
+		if tt.err != tt.want {
+			t.Errorf("%v = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+
+	history, err := s.GetAllHistory()
+	if err != nil || len(history) != 0 {
+		t.Errorf("GetAllHistory = %v, %v", history, err)
+	}
+	notes, err := s.GetNotes(1)
+	if err != nil || len(notes) != 0 {
+		t.Errorf("GetNotes = %v, %v", notes, err)
+	}
+	tags, err := s.GetTags()
+	if err != nil || len(tags) != 0 {
+		t.Errorf("GetTags = %v, %v", tags, err)
+	}
+	canned, err := s.LoadCanned()
+	if err != nil || len(canned) != 0 {
+		t.Errorf("LoadCanned = %v, %v", canned, err)
+	}
+}
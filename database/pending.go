@@ -0,0 +1,69 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//user waiting for an answer, a row of the msg sheet
+type Pending struct {
+	Id     int64
+	MsgIds []int
+	Since  time.Time
+}
+
+func (s sheetsSrv) GetPending() ([]Pending, error) {
+	rows, err := s.rows(s.msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	out := make([]Pending, 0, len(rows))
+	for _, row := range rows {
+		p, ok := parsePending(row)
+		if !ok {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+//user was answered, remove him from the queue
+func (s sheetsSrv) ClearPending(id int64) error {
+	s.msgMu.Lock()
+	defer s.msgMu.Unlock()
+	rows, err := s.lookup(s.msg, keyInt, id)
+	if err != nil {
+		return errors.Wrap(err, "lookup")
+	}
+	for _, row := range rows {
+		err = s.clearRow(row, id)
+		if err != nil {
+			return errors.Wrap(err, "clearRow")
+		}
+	}
+	return nil
+}
+
+//user id, message ids newest first, unix time of the first message
+func parsePending(row []interface{}) (Pending, bool) {
+	id, ok := parseId(cell(row, 0))
+	if !ok {
+		return Pending{}, false
+	}
+	p := Pending{Id: id}
+	for _, idStr := range strings.Split(cell(row, 1), ",") {
+		msgId, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil {
+			continue
+		}
+		p.MsgIds = append([]int{msgId}, p.MsgIds...)
+	}
+	if ts, err := strconv.ParseInt(cell(row, 2), 10, 64); err == nil {
+		p.Since = time.Unix(ts, 0)
+	}
+	return p, true
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/sheets/v4"
+)
+
+//how long row positions are trusted before the column is read again
+const indexTTL = time.Minute
+
+//type of the values in a key column
+type keyKind int
+
+const (
+	keyString keyKind = iota
+	//chat and user ids, rows with anything else in the column are
+	//headers or notes and are skipped
+	keyInt
+)
+
+//row numbers (from 1, like in sheets) by the exact value of one column
+type rowIndex struct {
+	column int
+	kind   keyKind
+	header int
+	rows   map[string][]int
+	size   int
+}
+
+//index of values read from the first row of a sheet, the first header rows
+//are never matched
+func newRowIndex(values [][]interface{}, column int, kind keyKind, header int) *rowIndex {
+	x := &rowIndex{
+		column: column,
+		kind:   kind,
+		header: header,
+		rows:   make(map[string][]int),
+		size:   len(values),
+	}
+	for i, row := range values {
+		x.Set(i+1, cell(row, column))
+	}
+	return x
+}
+
+//normalized key, false for empty cells and values of a wrong type
+func (x *rowIndex) key(v interface{}) (string, bool) {
+	s := strings.TrimSpace(fmt.Sprint(v))
+	if s == "" {
+		return "", false
+	}
+	if x.kind == keyString {
+		return s, true
+	}
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return strconv.FormatInt(id, 10), true
+	}
+	//numbers formatted by sheets like 123.0
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return "", false
+	}
+	return strconv.FormatInt(int64(f), 10), true
+}
+
+//user or chat id from a cell
+func parseId(s string) (int64, bool) {
+	k, ok := (&rowIndex{kind: keyInt}).key(s)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(k, 10, 64)
+	return id, err == nil
+}
+
+//rows holding exactly v
+func (x *rowIndex) Find(v interface{}) []int {
+	k, ok := x.key(v)
+	if !ok {
+		return nil
+	}
+	return x.rows[k]
+}
+
+//the only row holding v, 0 if there is none
+func (x *rowIndex) One(v interface{}) (int, error) {
+	rows := x.Find(v)
+	switch len(rows) {
+	case 0:
+		return 0, nil
+	case 1:
+		return rows[0], nil
+	default:
+		return 0, errors.Errorf("%v is in %d rows", v, len(rows))
+	}
+}
+
+//row now holds v
+func (x *rowIndex) Set(row int, v interface{}) {
+	if row > x.size {
+		x.size = row
+	}
+	if row <= x.header {
+		return
+	}
+	k, ok := x.key(v)
+	if !ok {
+		return
+	}
+	for _, r := range x.rows[k] {
+		if r == row {
+			return
+		}
+	}
+	x.rows[k] = append(x.rows[k], row)
+}
+
+//row no longer holds v
+func (x *rowIndex) Remove(row int, v interface{}) {
+	k, ok := x.key(v)
+	if !ok {
+		return
+	}
+	rows := x.rows[k]
+	for i, r := range rows {
+		if r == row {
+			rows = append(rows[:i:i], rows[i+1:]...)
+			break
+		}
+	}
+	if len(rows) == 0 {
+		delete(x.rows, k)
+		return
+	}
+	x.rows[k] = rows
+}
+
+//number of distinct keys
+func (x *rowIndex) Len() int {
+	return len(x.rows)
+}
+
+//indexes of key columns shared by copies of sheetsSrv
+type indexCache struct {
+	mu      sync.Mutex
+	indexes map[string]*cachedIndex
+}
+
+type cachedIndex struct {
+	index  *rowIndex
+	loaded time.Time
+}
+
+func newIndexCache() *indexCache {
+	return &indexCache{indexes: make(map[string]*cachedIndex)}
+}
+
+//index of the key column of a table, read again when it is older than
+//indexTTL or when v is not in it, as someone else may have added the row
+func (s sheetsSrv) lookup(t *table, kind keyKind, v interface{}) ([]int, error) {
+	s.indexes.mu.Lock()
+	var rows []int
+	if cached, ok := s.indexes.indexes[t.name]; ok && time.Since(cached.loaded) < indexTTL {
+		rows = append(rows, cached.index.Find(v)...)
+	}
+	s.indexes.mu.Unlock()
+	if len(rows) > 0 {
+		return rows, nil
+	}
+	rsp, err := s.srv.Spreadsheets.Values.Get(t.id, t.keys()).Do()
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	x := newRowIndex(rsp.Values, 0, kind, t.header)
+	s.reindex(t, x)
+	return x.Find(v), nil
+}
+
+//replace the cached index of a table with one built from fresh values
+func (s sheetsSrv) reindex(t *table, x *rowIndex) *rowIndex {
+	s.indexes.mu.Lock()
+	s.indexes.indexes[t.name] = &cachedIndex{index: x, loaded: time.Now()}
+	s.indexes.mu.Unlock()
+	return x
+}
+
+//record the row written by Append
+func (s sheetsSrv) appended(t *table, rsp *sheets.AppendValuesResponse, v interface{}) {
+	row := updatedRow(rsp)
+	s.indexes.mu.Lock()
+	defer s.indexes.mu.Unlock()
+	cached, ok := s.indexes.indexes[t.name]
+	if !ok {
+		return
+	}
+	if row == 0 {
+		delete(s.indexes.indexes, t.name)
+		return
+	}
+	cached.index.Set(row, v)
+}
+
+//record a cleared row
+func (s sheetsSrv) cleared(t *table, row int, v interface{}) {
+	s.indexes.mu.Lock()
+	defer s.indexes.mu.Unlock()
+	if cached, ok := s.indexes.indexes[t.name]; ok {
+		cached.index.Remove(row, v)
+	}
+}
+
+var updatedRangeRe = regexp.MustCompile(`![A-Z]+(\d+)`)
+
+//first row of the range written by Append, 0 if unknown
+func updatedRow(rsp *sheets.AppendValuesResponse) int {
+	if rsp == nil || rsp.Updates == nil {
+		return 0
+	}
+	m := updatedRangeRe.FindStringSubmatch(rsp.Updates.UpdatedRange)
+	if m == nil {
+		return 0
+	}
+	row, _ := strconv.Atoi(m[1])
+	return row
+}
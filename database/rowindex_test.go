@@ -0,0 +1,118 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestParseId(t *testing.T) {
+	tests := []struct {
+		in string
+		id int64
+		ok bool
+	}{
+		{"42", 42, true},
+		{" 42 ", 42, true},
+		{"-1001234567890", -1001234567890, true},
+		{"12345678901.0", 12345678901, true},
+		{"1.5e3", 1500, true},
+		{"42.5", 0, false},
+		{"", 0, false},
+		{"id", 0, false},
+		{"@nick", 0, false},
+		{"1e20", 0, false},
+	}
+	for _, tt := range tests {
+		id, ok := parseId(tt.in)
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("parseId(%q) = %v %v, want %v %v", tt.in, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestRowIndex(t *testing.T) {
+	values := [][]interface{}{
+		{"id", "name"},
+		{"42", "a"},
+		{},
+		{"420", "b"},
+		{"42.0", "c"},
+		{"note: 42"},
+		{float64(7), "d"},
+	}
+	x := newRowIndex(values, 0, keyInt, 1)
+
+	tests := []struct {
+		key  interface{}
+		rows []int
+	}{
+		//exact match, 420 and the note do not hold 42
+		{42, []int{2, 5}},
+		{"42", []int{2, 5}},
+		{int64(420), []int{4}},
+		{"7", []int{7}},
+		{"id", nil},
+		{"", nil},
+		{1, nil},
+	}
+	for _, tt := range tests {
+		if rows := x.Find(tt.key); !reflect.DeepEqual(rows, tt.rows) {
+			t.Errorf("Find(%v) = %v, want %v", tt.key, rows, tt.rows)
+		}
+	}
+
+	if _, err := x.One(42); err == nil {
+		t.Error("One(42): no error for two rows")
+	}
+	if row, err := x.One(420); row != 4 || err != nil {
+		t.Errorf("One(420) = %v %v, want 4", row, err)
+	}
+	if row, err := x.One(1); row != 0 || err != nil {
+		t.Errorf("One(1) = %v %v, want 0", row, err)
+	}
+
+	x.Remove(5, "42")
+	x.Set(8, 1)
+	//header rows are never matched
+	x.Set(1, 100)
+	if rows := x.Find(42); !reflect.DeepEqual(rows, []int{2}) {
+		t.Errorf("Find(42) after Remove = %v, want [2]", rows)
+	}
+	if rows := x.Find(1); !reflect.DeepEqual(rows, []int{8}) {
+		t.Errorf("Find(1) after Set = %v, want [8]", rows)
+	}
+	if rows := x.Find(100); rows != nil {
+		t.Errorf("Find(100) = %v, header row matched", rows)
+	}
+	if x.size != 8 || x.Len() != 4 {
+		t.Errorf("size %v, %v keys, want 8 and 4", x.size, x.Len())
+	}
+
+	names := newRowIndex([][]interface{}{{"nick"}, {" Nick "}, {"nick"}}, 0, keyString, 0)
+	if rows := names.Find("nick"); !reflect.DeepEqual(rows, []int{1, 3}) {
+		t.Errorf("Find(nick) = %v, want [1 3]", rows)
+	}
+}
+
+func TestUpdatedRow(t *testing.T) {
+	tests := []struct {
+		rng string
+		row int
+	}{
+		{"Sheet1!A12:E12", 12},
+		{"'Контакты'!B7:F7", 7},
+		{"'a!b'!C3", 3},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		rsp := &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: tt.rng}}
+		if row := updatedRow(rsp); row != tt.row {
+			t.Errorf("updatedRow(%q) = %v, want %v", tt.rng, row, tt.row)
+		}
+	}
+	if row := updatedRow(nil); row != 0 {
+		t.Errorf("updatedRow(nil) = %v", row)
+	}
+}
@@ -0,0 +1,356 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	TableUsers      = "users"
+	TableMsg        = "msg"
+	TableAdmins     = "admins"
+	TableBanned     = "banned"
+	TableCanned     = "canned"
+	TableHistory    = "history"
+	TableNotes      = "notes"
+	TableTags       = "tags"
+	TableBroadcasts = "broadcasts"
+
+	defaultTab = "Sheet1"
+)
+
+//all tables in a stable order
+var Tables = []string{TableUsers, TableMsg, TableAdmins, TableBanned, TableCanned, TableHistory, TableNotes, TableTags, TableBroadcasts}
+
+//fields of every table in the order the code reads and writes them,
+//without a layout they are the columns A, B, ...
+var Fields = map[string][]string{
+	TableUsers:      {"id", "name", "nick", "region", "created", "blocked"},
+	TableMsg:        {"id", "messages", "since"},
+	TableAdmins:     {"nick", "chat_id"},
+	TableBanned:     {"nick"},
+	TableCanned:     {"name", "text"},
+	TableHistory:    {"user_id", "msg_id", "direction", "admin", "type", "file_id", "text", "time"},
+	TableNotes:      {"user_id", "admin", "text", "time"},
+	TableTags:       {"user_id", "tag"},
+	TableBroadcasts: {"time", "admin", "sent", "blocked", "failed"},
+}
+
+//fields added later, tables without their column still work
+var optional = map[string]map[string]bool{
+	TableUsers: {"blocked": true},
+}
+
+//where a table lives: Spreadsheet overrides the id from env, Tab defaults to
+//Sheet1 or to the table name in a shared spreadsheet, Columns maps fields to
+//headers of the first row
+type Layout struct {
+	Spreadsheet string            `json:"spreadsheet"`
+	Tab         string            `json:"tab"`
+	Columns     map[string]string `json:"columns"`
+}
+
+//layouts by table name
+type Schema map[string]Layout
+
+//schema from a json file, empty path is the legacy layout
+func LoadSchema(path string) (Schema, error) {
+	schema := make(Schema)
+	if path == "" {
+		return schema, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+	err = json.Unmarshal(data, &schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+	for name, layout := range schema {
+		fields, ok := Fields[name]
+		if !ok {
+			return nil, errors.Errorf("unknown table %q", name)
+		}
+		if len(layout.Columns) == 0 {
+			continue
+		}
+		for field := range layout.Columns {
+			if indexOf(fields, field) < 0 {
+				return nil, errors.Errorf("%v: unknown field %q", name, field)
+			}
+		}
+		for _, field := range fields {
+			if layout.Columns[field] == "" && !optional[name][field] {
+				return nil, errors.Errorf("%v: no header for %q", name, field)
+			}
+		}
+	}
+	return schema, nil
+}
+
+//table resolved against the spreadsheet
+type table struct {
+	name     string
+	id       string
+	tab      string
+	sheetId  int64
+	gridRows int64
+	//rows above the data
+	header int
+	//sheet column of each field, -1 for a missing optional one
+	cols []int
+	//columns read, up to the last mapped one
+	width int
+}
+
+//A1 range of all mapped columns
+func (t *table) all() string {
+	return fmt.Sprintf("%v!A:%v", t.quoted(), column(t.width))
+}
+
+//A1 range of the key column
+func (t *table) keys() string {
+	c := column(t.cols[0] + 1)
+	return fmt.Sprintf("%v!%v:%v", t.quoted(), c, c)
+}
+
+//A1 range of a whole row
+func (t *table) rowRange(row int) string {
+	return fmt.Sprintf("%v!A%d:%v%d", t.quoted(), row, column(t.width), row)
+}
+
+//A1 range of one field of a row
+func (t *table) cellRange(row, field int) string {
+	c := column(t.cols[field] + 1)
+	return fmt.Sprintf("%v!%v%d:%v%d", t.quoted(), c, row, c, row)
+}
+
+func (t *table) quoted() string {
+	return "'" + strings.Replace(t.tab, "'", "''", -1) + "'"
+}
+
+//row of field values laid out as sheet columns, unmapped columns are nil
+//and left untouched by sheets
+func (t *table) toSheet(values []interface{}) []interface{} {
+	out := make([]interface{}, t.width)
+	for field, v := range values {
+		if t.cols[field] >= 0 {
+			out[t.cols[field]] = v
+		}
+	}
+	return out
+}
+
+//sheet row as field values
+func (t *table) fromSheet(row []interface{}) []interface{} {
+	out := make([]interface{}, len(t.cols))
+	for field, c := range t.cols {
+		out[field] = ""
+		if c >= 0 {
+			out[field] = cell(row, c)
+		}
+	}
+	return out
+}
+
+//sheet rows as field values, header rows are left empty so that the
+//index of a row is still its number - 1
+func (t *table) read(rows [][]interface{}) [][]interface{} {
+	out := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		if i < t.header || len(row) == 0 {
+			out[i] = []interface{}{}
+			continue
+		}
+		out[i] = t.fromSheet(row)
+	}
+	return out
+}
+
+//empty values for every field, written to clear a row
+func (t *table) blank() []interface{} {
+	out := make([]interface{}, len(t.cols))
+	for i := range out {
+		out[i] = ""
+	}
+	return out
+}
+
+//spreadsheet and tab of every table. Without a tab in the schema a table
+//is on Sheet1 when it has the spreadsheet to itself and on the tab named
+//after it when it shares one, two tables on the same tab are rejected
+func placement(ids map[string]string, schema Schema) (map[string]string, map[string]string, error) {
+	sheetIds := make(map[string]string, len(Tables))
+	shared := make(map[string]int)
+	for _, name := range Tables {
+		id := ids[name]
+		if schema[name].Spreadsheet != "" {
+			id = schema[name].Spreadsheet
+		}
+		sheetIds[name] = id
+		if id != "" {
+			shared[id]++
+		}
+	}
+	tabs := make(map[string]string, len(Tables))
+	owners := make(map[string]string)
+	for _, name := range Tables {
+		tab := schema[name].Tab
+		if tab == "" {
+			tab = defaultTab
+			if shared[sheetIds[name]] > 1 {
+				tab = name
+			}
+		}
+		tabs[name] = tab
+		if sheetIds[name] == "" {
+			continue
+		}
+		key := sheetIds[name] + "!" + tab
+		if other, ok := owners[key]; ok {
+			return nil, nil, errors.Errorf("%v and %v are both on tab %q of %v", other, name, tab, sheetIds[name])
+		}
+		owners[key] = name
+	}
+	return sheetIds, tabs, nil
+}
+
+//find tabs and header columns of every table, ids are spreadsheets by table
+func resolve(srv *sheets.Service, ids map[string]string, schema Schema) (map[string]*table, error) {
+	sheetIds, tabs, err := placement(ids, schema)
+	if err != nil {
+		return nil, err
+	}
+	metas := make(map[string]*sheets.Spreadsheet)
+	out := make(map[string]*table)
+	for name, fields := range Fields {
+		layout := schema[name]
+		t := &table{
+			name: name,
+			id:   sheetIds[name],
+			tab:  tabs[name],
+			cols: make([]int, len(fields)),
+		}
+		for i := range t.cols {
+			t.cols[i] = i
+		}
+		//optional tables
+		if t.id == "" {
+			out[name] = t
+			t.width = len(fields)
+			continue
+		}
+
+		meta, ok := metas[t.id]
+		if !ok {
+			var err error
+			meta, err = srv.Spreadsheets.Get(t.id).Fields("sheets.properties").Do()
+			if err != nil {
+				return nil, errors.Wrapf(err, "%v: Get", name)
+			}
+			metas[t.id] = meta
+		}
+		found := false
+		for _, sheet := range meta.Sheets {
+			if sheet.Properties.Title != t.tab {
+				continue
+			}
+			found = true
+			t.sheetId = sheet.Properties.SheetId
+			if sheet.Properties.GridProperties != nil {
+				t.gridRows = sheet.Properties.GridProperties.RowCount
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("%v: no tab %q in %v", name, t.tab, t.id)
+		}
+
+		rsp, err := srv.Spreadsheets.Values.Get(t.id, t.quoted()+"!1:1").Do()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%v: Get header", name)
+		}
+		var header []interface{}
+		if len(rsp.Values) > 0 {
+			header = rsp.Values[0]
+		}
+		cols, missing := headerColumns(header, name, layout.Headers(name))
+		switch {
+		case missing == "":
+			t.cols = cols
+			t.header = 1
+		//without columns in the schema a header row of field names is
+		//optional, as written by init-sheets
+		case len(layout.Columns) > 0:
+			return nil, errors.Errorf("%v: no column %q in %q", name, missing, t.tab)
+		}
+		for _, c := range t.cols {
+			if c+1 > t.width {
+				t.width = c + 1
+			}
+		}
+		out[name] = t
+	}
+	return out, nil
+}
+
+//headers of the fields of a table, field names when the layout has none
+func (l Layout) Headers(name string) []string {
+	fields := Fields[name]
+	out := make([]string, len(fields))
+	for i, field := range fields {
+		out[i] = field
+		if len(l.Columns) > 0 {
+			out[i] = l.Columns[field]
+		}
+	}
+	return out
+}
+
+//column of every header of a table, or the first required header that is
+//missing, names are in the order of Fields
+func headerColumns(header []interface{}, table string, names []string) ([]int, string) {
+	cols := make([]int, len(names))
+	for i, name := range names {
+		cols[i] = headerColumn(header, name)
+		if cols[i] < 0 && !optional[table][Fields[table][i]] {
+			return nil, name
+		}
+	}
+	return cols, ""
+}
+
+//index of a header, case and spaces are ignored, -1 if there is none
+func headerColumn(header []interface{}, name string) int {
+	for i := range header {
+		if strings.EqualFold(strings.TrimSpace(cell(header, i)), strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+//column letters of a 1-based column number
+func column(n int) string {
+	s := ""
+	for n > 0 {
+		n--
+		s = string(rune('A'+n%26)) + s
+		n /= 26
+	}
+	return s
+}
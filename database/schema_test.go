@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestLoadSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"tabs only", `{"users": {"tab": "Контакты"}, "msg": {"spreadsheet": "doc"}}`, ""},
+		{"all columns", `{"admins": {"columns": {"nick": "Ник", "chat_id": "Чат"}}}`, ""},
+		{"optional column", `{"users": {"columns": {"id": "ID", "name": "Имя", "nick": "Ник", "region": "Регион", "created": "Дата"}}}`, ""},
+		{"unknown table", `{"contacts": {"tab": "x"}}`, "unknown table"},
+		{"unknown field", `{"banned": {"columns": {"nick": "Ник", "id": "ID"}}}`, "unknown field"},
+		{"missing column", `{"admins": {"columns": {"nick": "Ник"}}}`, `no header for "chat_id"`},
+		{"bad json", `{"users": `, "Unmarshal"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "schema.json")
+		if err := ioutil.WriteFile(path, []byte(tt.schema), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadSchema(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%v: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%v: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	schema, err := LoadSchema("")
+	if err != nil || len(schema) != 0 {
+		t.Errorf("legacy schema = %v, %v", schema, err)
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	own := make(map[string]string)
+	for _, name := range Tables {
+		own[name] = "doc-" + name
+	}
+	shared := make(map[string]string)
+	for _, name := range Tables {
+		shared[name] = "doc"
+	}
+
+	tests := []struct {
+		name   string
+		ids    map[string]string
+		schema Schema
+		tabs   map[string]string
+		err    string
+	}{
+		{"own spreadsheets", own, Schema{}, map[string]string{TableUsers: defaultTab, TableMsg: defaultTab}, ""},
+		{"one spreadsheet", shared, Schema{}, map[string]string{TableUsers: TableUsers, TableMsg: TableMsg}, ""},
+		{"schema tab", shared, Schema{TableUsers: {Tab: "Контакты"}}, map[string]string{TableUsers: "Контакты", TableMsg: TableMsg}, ""},
+		{"moved by schema", own, Schema{TableMsg: {Spreadsheet: "doc-users"}}, map[string]string{TableUsers: TableUsers, TableMsg: TableMsg, TableAdmins: defaultTab}, ""},
+		{"not configured", map[string]string{TableUsers: "doc"}, Schema{}, map[string]string{TableUsers: defaultTab, TableNotes: defaultTab}, ""},
+		{"same tab", shared, Schema{TableUsers: {Tab: "data"}, TableMsg: {Tab: "data"}}, nil, `users and msg are both on tab "data"`},
+		{"tab named after another table", shared, Schema{TableUsers: {Tab: TableMsg}}, nil, "both on tab"},
+	}
+	for _, tt := range tests {
+		_, tabs, err := placement(tt.ids, tt.schema)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		for table, tab := range tt.tabs {
+			if tabs[table] != tab {
+				t.Errorf("%v: tab of %v = %q, want %q", tt.name, table, tabs[table], tab)
+			}
+		}
+	}
+}
+
+func TestHeaderColumns(t *testing.T) {
+	header := []interface{}{"Дата", " ID ", "имя", "Ник", "", "Регион"}
+	tests := []struct {
+		name    string
+		table   string
+		names   []string
+		cols    []int
+		missing string
+	}{
+		{"case and spaces", TableUsers, []string{"id", "Имя", "ник", "регион", "дата", "Заблокирован"}, []int{1, 2, 3, 5, 0, -1}, ""},
+		{"required missing", TableUsers, []string{"id", "Имя", "ник", "Город", "дата", "Заблокирован"}, nil, "Город"},
+		{"field names", TableBanned, []string{"nick"}, nil, "nick"},
+	}
+	for _, tt := range tests {
+		cols, missing := headerColumns(header, tt.table, tt.names)
+		if missing != tt.missing || !reflect.DeepEqual(cols, tt.cols) {
+			t.Errorf("%v: %v %q, want %v %q", tt.name, cols, missing, tt.cols, tt.missing)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := map[int]string{1: "A", 6: "F", 26: "Z", 27: "AA", 52: "AZ", 703: "AAA"}
+	for n, want := range tests {
+		if got := column(n); got != want {
+			t.Errorf("column(%v) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+//sheets api with the tabs of one spreadsheet and their first rows
+func fakeSpreadsheet(t *testing.T, headers map[string][]interface{}) *sheets.Service {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		//values/'tab'!1:1
+		if i := strings.Index(r.URL.Path, "/values/"); i >= 0 {
+			tab := strings.TrimSuffix(r.URL.Path[i+len("/values/"):], "!1:1")
+			tab = strings.Replace(strings.Trim(tab, "'"), "''", "'", -1)
+			rsp := sheets.ValueRange{}
+			if h, ok := headers[tab]; ok {
+				rsp.Values = [][]interface{}{h}
+			}
+			json.NewEncoder(w).Encode(rsp)
+			return
+		}
+		meta := sheets.Spreadsheet{}
+		id := int64(1)
+		for tab := range headers {
+			meta.Sheets = append(meta.Sheets, &sheets.Sheet{Properties: &sheets.SheetProperties{
+				Title: tab, SheetId: id, GridProperties: &sheets.GridProperties{RowCount: 1000},
+			}})
+			id++
+		}
+		json.NewEncoder(w).Encode(meta)
+	}))
+	t.Cleanup(ts.Close)
+	srv, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(ts.URL+"/"),
+		option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestResolve(t *testing.T) {
+	headers := make(map[string][]interface{})
+	for _, name := range Tables {
+		headers[name] = nil
+	}
+	headers["Контакты"] = []interface{}{"Ник", "ID", "Имя", "Регион", "Дата"}
+	headers[TableAdmins] = []interface{}{"nick", "chat_id"}
+	delete(headers, TableUsers)
+	srv := fakeSpreadsheet(t, headers)
+
+	ids := make(map[string]string)
+	for _, name := range Tables {
+		ids[name] = "doc"
+	}
+	schema := Schema{TableUsers: {Tab: "Контакты", Columns: map[string]string{
+		"id": "ID", "name": "Имя", "nick": "Ник", "region": "Регион", "created": "Дата", "blocked": "Заблокирован",
+	}}}
+	tables, err := resolve(srv, ids, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table  string
+		tab    string
+		header int
+		cols   []int
+		width  int
+	}{
+		{TableUsers, "Контакты", 1, []int{1, 2, 0, 3, 4, -1}, 5},
+		//field names written by init-sheets
+		{TableAdmins, TableAdmins, 1, []int{0, 1}, 2},
+		//no header row, legacy columns
+		{TableMsg, TableMsg, 0, []int{0, 1, 2}, 3},
+	}
+	for _, tt := range tests {
+		tb := tables[tt.table]
+		if tb.tab != tt.tab || tb.header != tt.header || !reflect.DeepEqual(tb.cols, tt.cols) || tb.width != tt.width {
+			t.Errorf("%v: tab %q header %v cols %v width %v, want %q %v %v %v",
+				tt.table, tb.tab, tb.header, tb.cols, tb.width, tt.tab, tt.header, tt.cols, tt.width)
+		}
+	}
+
+	//columns in the schema need their headers
+	schema[TableMsg] = Layout{Columns: map[string]string{"id": "ID", "messages": "Сообщения", "since": "С"}}
+	if _, err := resolve(srv, ids, schema); err == nil || !strings.Contains(err.Error(), `no column "ID"`) {
+		t.Errorf("missing header: %v", err)
+	}
+	delete(schema, TableMsg)
+
+	//a tab that is not there
+	schema[TableUsers] = Layout{Tab: "Пользователи"}
+	if _, err := resolve(srv, ids, schema); err == nil || !strings.Contains(err.Error(), `no tab "Пользователи"`) {
+		t.Errorf("missing tab: %v", err)
+	}
+}
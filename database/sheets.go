@@ -3,96 +3,178 @@ package database
 import (
 	"fmt"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"google.golang.org/api/googleapi"
 	"google.golang.org/api/sheets/v4"
 )
 
-var errNoRows = errors.New("no rows")
+var ErrNoRows = errors.New("no rows")
+
+//write to an optional table without a spreadsheet
+var ErrDisabled = errors.New("table is not configured")
 
 type Admin struct {
 	Nick   string
 	ChatId int64
 }
 
+type Contact struct {
+	Id      int64
+	Name    string
+	Nick    string
+	Region  string
+	Created time.Time
+	//when the user blocked the bot, zero while the bot can write to them
+	Blocked time.Time
+}
+
 type sheetsSrv struct {
-	srv    *sheets.Service
-	db     string
-	msg    string
-	admins string
-	banned string
+	//guards read-modify-write of the msg sheet
+	msgMu      *sync.Mutex
+	indexes    *indexCache
+	srv        *sheets.Service
+	users      *table
+	msg        *table
+	admins     *table
+	banned     *table
+	canned     *table
+	history    *table
+	notes      *table
+	tags       *table
+	broadcasts *table
 }
 
+//ids are spreadsheets of the tables, the schema says where in them the tables
+//are and is resolved here by reading tabs and header rows
 func NewSheetsSrv(
 	srv *sheets.Service,
 	db string,
 	msg string,
 	admins string,
-	banned string) *sheetsSrv {
+	banned string,
+	canned string,
+	history string,
+	notes string,
+	tags string,
+	broadcasts string,
+	schema Schema) (*sheetsSrv, error) {
+	tables, err := resolve(srv, map[string]string{
+		TableUsers:      db,
+		TableMsg:        msg,
+		TableAdmins:     admins,
+		TableBanned:     banned,
+		TableCanned:     canned,
+		TableHistory:    history,
+		TableNotes:      notes,
+		TableTags:       tags,
+		TableBroadcasts: broadcasts,
+	}, schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve")
+	}
 	return &sheetsSrv{
-		srv:    srv,
-		db:     db,
-		msg:    msg,
-		admins: admins,
-		banned: banned,
+		msgMu:      &sync.Mutex{},
+		indexes:    newIndexCache(),
+		srv:        srv,
+		users:      tables[TableUsers],
+		msg:        tables[TableMsg],
+		admins:     tables[TableAdmins],
+		banned:     tables[TableBanned],
+		canned:     tables[TableCanned],
+		history:    tables[TableHistory],
+		notes:      tables[TableNotes],
+		tags:       tables[TableTags],
+		broadcasts: tables[TableBroadcasts],
+	}, nil
+}
+
+//rows of a table as field values
+func (s sheetsSrv) rows(t *table) ([][]interface{}, error) {
+	rsp, err := s.srv.Spreadsheets.Values.Get(t.id, t.all()).Do()
+	if err != nil {
+		return nil, err
 	}
+	return t.read(rsp.Values), nil
 }
 
-func LoadColumnAsString(s sheetsSrv, table string) (map[string]struct{}, error) {
+//write field values to a row
+func (s sheetsSrv) updateRow(t *table, row int, values []interface{}) error {
+	valRen := sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{t.toSheet(values)},
+	}
+	_, err := s.srv.Spreadsheets.Values.
+		Update(t.id, t.rowRange(row), &valRen).
+		ValueInputOption("RAW").
+		Do()
+	return err
+}
+
+//append rows of field values
+func (s sheetsSrv) appendRows(t *table, rows ...[]interface{}) (*sheets.AppendValuesResponse, error) {
+	values := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, t.toSheet(row))
+	}
+	valRen := sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         values,
+	}
+	return s.srv.Spreadsheets.Values.
+		Append(t.id, t.all(), &valRen).
+		ValueInputOption("RAW").
+		InsertDataOption("INSERT_ROWS").
+		Do()
+}
+
+//cell value as string, empty if the row is shorter
+func cell(row []interface{}, i int) string {
+	if i >= len(row) {
+		return ""
+	}
+	return fmt.Sprint(row[i])
+}
+
+func LoadColumnAsString(s sheetsSrv, t *table) (map[string]struct{}, error) {
 	out := make(map[string]struct{})
-	rsp, err := s.srv.Spreadsheets.Values.Get(table, "Sheet1!A:A").Do()
+	rows, err := s.rows(t)
 	if err != nil {
 		return nil, errors.Wrap(err, "Get")
 	}
-	for _, row := range rsp.Values {
-		nick, ok := row[0].(string)
-		if !ok {
-			return nil, errors.Wrap(err, "not a string")
+	for _, row := range rows {
+		nick := cell(row, 0)
+		if nick == "" {
+			continue
 		}
 		out[nick] = struct{}{}
 	}
 	return out, nil
 }
 
-func SaveValue(s sheetsSrv, table string, value string) error {
-	inValue := make([]interface{}, 1)
-	inValue[0] = value
-	outValue := make([][]interface{}, 1)
-	outValue[0] = inValue
-	valRen := sheets.ValueRange{
-		MajorDimension:  "ROWS",
-		Range:           "",
-		Values:          outValue,
-		ServerResponse:  googleapi.ServerResponse{},
-		ForceSendFields: nil,
-		NullFields:      nil,
-	}
-	_, err := s.srv.Spreadsheets.Values.
-		Append(table, "Sheet1!A:B", &valRen).
-		ValueInputOption("RAW").
-		Do()
+func SaveValue(s sheetsSrv, t *table, value string) error {
+	_, err := s.appendRows(t, []interface{}{value})
 	if err != nil {
 		return errors.Wrap(err, "Unable to append value")
 	}
 	return nil
 }
 
+//admins: nick, chat id
 func (s sheetsSrv) LoadAdmins() (map[string]Admin, error) {
 	out := make(map[string]Admin)
-	rsp, err := s.srv.Spreadsheets.Values.Get(s.admins, "Sheet1!A:B").Do()
+	rows, err := s.rows(s.admins)
 	if err != nil {
 		return nil, errors.Wrap(err, "Get")
 	}
-	for _, row := range rsp.Values {
-		nick, ok := row[0].(string)
-		if !ok {
-			return nil, errors.Wrap(err, "not a string")
+	for _, row := range rows {
+		nick := cell(row, 0)
+		if nick == "" {
+			continue
 		}
-		chat_id, _ := strconv.Atoi(row[1].(string))
-		out[nick] = Admin{nick, int64(chat_id)}
+		chat_id, _ := parseId(cell(row, 1))
+		out[nick] = Admin{nick, chat_id}
 	}
 	return out, nil
 }
@@ -109,298 +191,244 @@ func (s sheetsSrv) SetBan(nick string) error {
 	return SaveValue(s, s.banned, nick)
 }
 
+//the user who waits the longest, the row stays until ClearPending
 func (s sheetsSrv) GetLast() (int64, []int, error) {
-	err := s.sortSheet()
+	rows, err := s.rows(s.msg)
 	if err != nil {
-		return 0, nil, errors.Wrap(err, "sortSheet")
+		return 0, nil, errors.Wrap(err, "Get")
 	}
-	rsp, err := s.srv.Spreadsheets.Values.
-		Get(s.msg, "Sheet1!A1:B1").
-		Do()
+	row := 0
+	var oldest Pending
+	for i, values := range rows {
+		p, ok := parsePending(values)
+		if !ok || len(p.MsgIds) == 0 {
+			continue
+		}
+		if row == 0 || p.Since.Before(oldest.Since) {
+			row, oldest = i+1, p
+		}
+	}
+	if row == 0 {
+		return 0, nil, ErrNoRows
+	}
+	return oldest.Id, oldest.MsgIds, nil
+}
+
+func (s sheetsSrv) SaveContact(id int64, name, nick string) error {
+	rows, err := s.lookup(s.users, keyInt, id)
 	if err != nil {
-		return 0, nil, errors.Wrap(err, "Get")
+		return errors.Wrap(err, "lookup")
 	}
-	if len(rsp.Values) == 0 {
-		return 0, nil, errNoRows
+	if len(rows) > 0 {
+		return errors.New("duplicate")
 	}
-	id, err := strconv.Atoi(rsp.Values[0][0].(string))
+	rsp, err := s.appendRows(s.users, []interface{}{id, name, nick, "", time.Now().Unix()})
 	if err != nil {
-		return 0, nil, errors.Wrap(err, "Atoi")
+		return errors.Wrap(err, "Unable to retrieve files")
 	}
-	idsStr := rsp.Values[0][1].(string)
-	msgIdsStrs := strings.Split(idsStr, ",")
-	msgIds := make([]int, len(msgIdsStrs))
-	for i, id := range msgIdsStrs {
-		idint, err := strconv.Atoi(id)
-		if err != nil {
-			return 0, nil, errors.Wrap(err, "Atoi")
+	s.appended(s.users, rsp, id)
+	return nil
+}
+
+//users: id, name, nick, region, unix time of /start
+func (s sheetsSrv) GetContacts() ([]Contact, error) {
+	rows, err := s.rows(s.users)
+	if err != nil {
+		return nil, errors.Wrap(err, "Get")
+	}
+	out := make([]Contact, 0, len(rows))
+	for _, row := range rows {
+		c, ok := parseContact(row)
+		if !ok {
+			continue
 		}
-		msgIds[len(msgIds)-i-1] = idint
+		out = append(out, c)
 	}
-	err = s.clearRow()
+	return out, nil
+}
+
+func (s sheetsSrv) GetContact(id int64) (*Contact, error) {
+	contacts, err := s.GetContacts()
 	if err != nil {
-		return 0, nil, errors.Wrap(err, "clearRow")
+		return nil, errors.Wrap(err, "GetContacts")
 	}
-	err = s.sortSheet()
-	if err != nil {
-		return 0, nil, errors.Wrap(err, "sortSheet")
+	for _, c := range contacts {
+		if c.Id == id {
+			return &c, nil
+		}
 	}
-	return int64(id), msgIds, nil
+	return nil, nil
 }
 
-func (s sheetsSrv) SaveContact(id int64, name, nick string) error {
-	_, ints, err := s.searchRows(s.db, strconv.Itoa(int(id)), "Sheet1!A:A")
-	if err != nil && err != errNoRows {
-		return errors.Wrap(err, "searchRows")
+func parseContact(row []interface{}) (Contact, bool) {
+	id, ok := parseId(cell(row, 0))
+	if !ok {
+		return Contact{}, false
 	}
-	if len(ints) > 0 {
-		return errors.New("duplicate")
+	c := Contact{
+		Id:     id,
+		Name:   cell(row, 1),
+		Nick:   cell(row, 2),
+		Region: cell(row, 3),
+	}
+	if ts, err := strconv.ParseInt(cell(row, 4), 10, 64); err == nil {
+		c.Created = time.Unix(ts, 0)
 	}
-	inValue := make([]interface{}, 3)
-	inValue[0] = id
-	inValue[1] = name
-	inValue[2] = nick
-	outValue := make([][]interface{}, 1)
-	outValue[0] = inValue
+	if ts, err := strconv.ParseInt(cell(row, 5), 10, 64); err == nil && ts > 0 {
+		c.Blocked = time.Unix(ts, 0)
+	}
+	return c, true
+}
+
+func (s sheetsSrv) SaveRegion(id int64, region string) error {
+	rows, err := s.lookup(s.users, keyInt, id)
+	if err != nil {
+		return errors.Wrap(err, "lookup")
+	}
+	if len(rows) != 1 {
+		return errors.New("contact not found")
+	}
+
 	valRen := sheets.ValueRange{
-		MajorDimension:  "ROWS",
-		Range:           "",
-		Values:          outValue,
-		ServerResponse:  googleapi.ServerResponse{},
-		ForceSendFields: nil,
-		NullFields:      nil,
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{region}},
 	}
 	_, err = s.srv.Spreadsheets.Values.
-		Append(s.db, "Sheet1!A:C", &valRen).
+		Update(s.users.id, s.users.cellRange(rows[0], 3), &valRen).
 		ValueInputOption("RAW").
 		Do()
 	if err != nil {
-		return errors.Wrap(err, "Unable to retrieve files")
+		return errors.Wrap(err, "unable to insert values")
 	}
+
 	return nil
 }
 
-func (s sheetsSrv) SaveRegion(id int64, region string) error {
-	_, ints, err := s.searchRows(s.db, strconv.Itoa(int(id)), "Sheet1!A:A")
-	if err != nil && err != errNoRows {
-		return errors.Wrap(err, "searchRows")
+//mark that the user blocked the bot or unblocked it
+func (s sheetsSrv) SetBlocked(id int64, blocked bool) error {
+	if s.users.cols[5] < 0 {
+		return errors.New("no blocked column")
+	}
+	rows, err := s.lookup(s.users, keyInt, id)
+	if err != nil {
+		return errors.Wrap(err, "lookup")
 	}
-	if len(ints) != 1 {
+	if len(rows) != 1 {
 		return errors.New("contact not found")
 	}
 
-	inValue := make([]interface{}, 1)
-	inValue[0] = region
-	outValue := make([][]interface{}, 1)
-	outValue[0] = inValue
-	r := fmt.Sprintf("Sheet1!D%d:D%d", ints[0], ints[0])
+	var value interface{} = ""
+	if blocked {
+		value = time.Now().Unix()
+	}
 	valRen := sheets.ValueRange{
-		MajorDimension:  "ROWS",
-		Range:           "",
-		Values:          outValue,
-		ServerResponse:  googleapi.ServerResponse{},
-		ForceSendFields: nil,
-		NullFields:      nil,
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{value}},
 	}
 	_, err = s.srv.Spreadsheets.Values.
-		Update(s.db, r, &valRen).
+		Update(s.users.id, s.users.cellRange(rows[0], 5), &valRen).
 		ValueInputOption("RAW").
 		Do()
 	if err != nil {
 		return errors.Wrap(err, "unable to insert values")
 	}
-
 	return nil
 }
 
 func (s sheetsSrv) GetAll() ([]int64, error) {
 	out := make([]int64, 0)
 	rsp, err := s.srv.Spreadsheets.Values.
-		Get(s.db, "Sheet1!A:A").
+		Get(s.users.id, s.users.keys()).
 		Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "Get")
 	}
-	if len(rsp.Values) == 0 {
-		return nil, errNoRows
-	}
-	for _, row := range rsp.Values {
-		id, err := strconv.Atoi(row[0].(string))
-		if err != nil {
-			return nil, errors.Wrap(err, "Atoi")
+	for i, row := range rsp.Values {
+		if i < s.users.header {
+			continue
+		}
+		//cleared rows
+		id, ok := parseId(cell(row, 0))
+		if !ok {
+			continue
 		}
-		out = append(out, int64(id))
+		out = append(out, id)
+	}
+	if len(out) == 0 {
+		return nil, ErrNoRows
 	}
 	return out, nil
 }
 
 func (s sheetsSrv) SaveMsg(id int64, msgId int) error {
-	fmt.Println("start")
+	return s.saveMsg(id, msgId, time.Now().Unix())
+}
+
+//since is the unix time of the message, kept only for the first unanswered
+func (s sheetsSrv) saveMsg(id int64, msgId int, since int64) error {
+	s.msgMu.Lock()
+	defer s.msgMu.Unlock()
 	//check if exists
-	valueRange, ints, err := s.searchRows(s.msg, strconv.FormatInt(id, 10), "Sheet1!A:C", )
-	//not a single line error
+	rows, err := s.rows(s.msg)
+	if err != nil {
+		return errors.Wrap(err, "Get")
+	}
+	row, err := s.reindex(s.msg, newRowIndex(rows, 0, keyInt, 0)).One(id)
 	if err != nil {
-		fmt.Println("err 1")
-		return errors.Wrap(err, "searchRows")
+		return errors.Wrap(err, "One")
 	}
-	fmt.Println(1)
 	//	insert instead of update
-	if len(ints) == 0 {
-		fmt.Println("first")
-		inValue := make([]interface{}, 3)
-		inValue[0] = id
-		inValue[1] = msgId
-		inValue[2] = time.Now().Unix()
-		outValue := make([][]interface{}, 1)
-		outValue[0] = inValue
-		valRen := sheets.ValueRange{
-			MajorDimension:  "ROWS",
-			Range:           "",
-			Values:          outValue,
-			ServerResponse:  googleapi.ServerResponse{},
-			ForceSendFields: nil,
-			NullFields:      nil,
-		}
-		_, err = s.srv.Spreadsheets.Values.
-			Append(s.msg, "Sheet1!A:C", &valRen).
-			ValueInputOption("RAW").
-			Do()
+	if row == 0 {
+		rsp, err := s.appendRows(s.msg, []interface{}{id, msgId, since})
 		if err != nil {
 			return errors.Wrap(err, "Append")
 		}
-		fmt.Println("end")
+		s.appended(s.msg, rsp, id)
 		return nil
 	}
-	fmt.Println(2)
-	if len(ints) != 1 {
-		return errors.New("not a single row")
-	}
-	inValue := make([]interface{}, 3)
-	inValue[0] = id
-	inValue[1] = strconv.Itoa(msgId) + "," + valueRange.Values[ints[0]-1][1].(string)
-	inValue[2] = time.Now().Unix()
-	outValue := make([][]interface{}, 1)
-	outValue[0] = inValue
-	r := fmt.Sprintf("Sheet1!A%d:C%d", ints[0], ints[0])
-	valRen := sheets.ValueRange{
-		MajorDimension:  "ROWS",
-		Range:           r,
-		Values:          outValue,
-		ServerResponse:  googleapi.ServerResponse{},
-		ForceSendFields: nil,
-		NullFields:      nil,
-	}
-	fmt.Println(r)
-	_, err = s.srv.Spreadsheets.Values.
-		Update(s.msg, r, &valRen).
-		ValueInputOption("RAW").
-		Do()
+	//keep the time of the first unanswered message
+	var first interface{} = since
+	if v := cell(rows[row-1], 2); v != "" {
+		first = v
+	}
+	err = s.updateRow(s.msg, row, []interface{}{id, strconv.Itoa(msgId) + "," + cell(rows[row-1], 1), first})
 	if err != nil {
 		return errors.Wrap(err, "Update")
 	}
-	fmt.Println("end")
 	return nil
 }
 
-func (s sheetsSrv) searchRows(sheetId, searchInput, searchRange string) (*sheets.ValueRange, []int, error) {
-	resp, err := s.srv.Spreadsheets.Values.
-		Get(sheetId, searchRange).
-		Do()
+//clear the msg row of user id
+func (s sheetsSrv) clearRow(row int, id int64) error {
+	err := s.updateRow(s.msg, row, s.msg.blank())
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "Unable to retrieve data from sheet")
+		return err
 	}
-	y := make([]int, 0)
-	for i, row := range resp.Values {
-		c1, ok := row[0].(string)
-		if !ok {
-			return nil, nil, errors.Wrap(err, "cast")
-		}
-		if strings.Contains(c1, searchInput) {
-			y = append(y, i+1)
-		}
-	}
-	return resp, y, nil
-}
-
-func (s *sheetsSrv) sortSheet() error {
-	sort := &sheets.SortRangeRequest{
-		Range: &sheets.GridRange{
-			EndColumnIndex:   3,
-			EndRowIndex:      0,
-			SheetId:          0,
-			StartColumnIndex: 0,
-			StartRowIndex:    0,
-			ForceSendFields:  nil,
-			NullFields:       nil,
-		},
-		SortSpecs: []*sheets.SortSpec{
-			{
-				BackgroundColor:           nil,
-				BackgroundColorStyle:      nil,
-				DataSourceColumnReference: nil,
-				DimensionIndex:            2,
-				ForegroundColor:           nil,
-				ForegroundColorStyle:      nil,
-				SortOrder:                 "ASCENDING",
-				ForceSendFields:           nil,
-				NullFields:                nil,
-			},
-		},
-		ForceSendFields: nil,
-		NullFields:      nil,
-	}
-	req := []*sheets.Request{{
-		SortRange: sort,
-	}}
-	reqSort := &sheets.BatchUpdateSpreadsheetRequest{
-		IncludeSpreadsheetInResponse: false,
-		Requests:                     req,
-		ResponseIncludeGridData:      false,
-		ResponseRanges:               nil,
-		ForceSendFields:              nil,
-		NullFields:                   nil,
-	}
-	_, err := s.srv.Spreadsheets.BatchUpdate(s.msg, reqSort).Do()
-	return err
-}
-
-func (s *sheetsSrv) clearRow() error {
-	inValue := make([]interface{}, 3)
-	inValue[0] = ""
-	inValue[1] = ""
-	inValue[2] = ""
-	outValue := make([][]interface{}, 1)
-	outValue[0] = inValue
-	valRen := sheets.ValueRange{
-		MajorDimension:  "ROWS",
-		Range:           "Sheet1!A1:C1",
-		Values:          outValue,
-		ServerResponse:  googleapi.ServerResponse{},
-		ForceSendFields: nil,
-		NullFields:      nil,
-	}
-	_, err := s.srv.Spreadsheets.Values.
-		Update(s.msg, "Sheet1!A1:C1", &valRen).
-		ValueInputOption("RAW").
-		Do()
-	fmt.Println("OUT")
-	return err
+	s.cleared(s.msg, row, id)
+	return nil
 }
 
 func (s sheetsSrv) GetStat() (map[string]int, error) {
 	out := make(map[string]int)
 
-	rsp, err := s.srv.Spreadsheets.Values.Get(s.db, "Sheet1!A:A").Do()
-	if err != nil {
-		return nil, errors.Wrap(err, "Get")
+	contacts, err := s.GetAll()
+	if err != nil && err != ErrNoRows {
+		return nil, errors.Wrap(err, "GetAll")
 	}
-	out["contacts"] = len(rsp.Values)
+	out["contacts"] = len(contacts)
 
-	rsp, err = s.srv.Spreadsheets.Values.Get(s.msg, "Sheet1!A:A").Do()
+	//cleared and broken rows are not waiting users
+	pending, err := s.GetPending()
 	if err != nil {
-		return nil, errors.Wrap(err, "Get")
+		return nil, errors.Wrap(err, "GetPending")
+	}
+	waiting := 0
+	for _, p := range pending {
+		if len(p.MsgIds) > 0 {
+			waiting++
+		}
 	}
-	out["messages"] = len(rsp.Values)
+	out["messages"] = waiting
 
 	return out, nil
 }
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+)
+
+//replay wait when the breaker has no cooldown
+const spoolInterval = 30 * time.Second
+
+//layer in front of sheetsSrv when writes are not batched: SaveMsg and
+//SaveHistory that fail because sheets can't be reached are kept in the queue
+//file and replayed in order once sheets answers again. Queued writes have
+//row 0, they are appended like new ones
+type spool struct {
+	*sheetsSrv
+
+	mu       sync.Mutex
+	pending  []pendingWrite
+	file     string
+	interval time.Duration
+	replayMu sync.Mutex
+	fileMu   sync.Mutex
+}
+
+func NewSpool(s *sheetsSrv, file string, interval time.Duration) (*spool, error) {
+	pending, err := readQueue(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "readQueue")
+	}
+	for _, p := range pending {
+		if p.Row > 0 {
+			return nil, errors.Errorf("%v has batched writes, send them with SHEETS_FLUSH first", file)
+		}
+	}
+	if interval <= 0 {
+		interval = spoolInterval
+	}
+	return &spool{
+		sheetsSrv: s,
+		pending:   pending,
+		file:      file,
+		interval:  interval,
+	}, nil
+}
+
+//writes waiting to be replayed
+func (s *spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+//replay every interval until ctx is done
+func (s *spool) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Flush()
+			if err != nil {
+				fmt.Printf("spool: %v\n", err)
+			}
+		}
+	}
+}
+
+//replay queued writes in order, stops at the first one sheets can't take
+//yet. Writes refused for their data, like a user in two msg rows, are dropped
+//so they don't hold the queue forever
+func (s *spool) Flush() error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	for {
+		s.mu.Lock()
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		p := s.pending[0]
+		s.mu.Unlock()
+
+		err := s.replay(p)
+		var apiErr *googleapi.Error
+		if err != nil && (offline(err) || errors.As(err, &apiErr)) {
+			return errors.Wrapf(err, "replay %v", p.Table)
+		}
+		if err != nil {
+			fmt.Printf("spool: dropping %v write %v: %v\n", p.Table, p.Values, err)
+		}
+		s.mu.Lock()
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+		err = s.save()
+		if err != nil {
+			return errors.Wrap(err, "save")
+		}
+	}
+}
+
+func (s *spool) replay(p pendingWrite) error {
+	switch p.Table {
+	case TableMsg:
+		id, msgId, since, err := spooledMsg(p.Values)
+		if err != nil {
+			return errors.Wrap(err, "spooledMsg")
+		}
+		return s.sheetsSrv.saveMsg(id, msgId, since)
+	case TableHistory:
+		if s.history.id == "" {
+			return nil
+		}
+		_, err := s.appendRows(s.history, p.Values)
+		return err
+	}
+	return nil
+}
+
+//new writes wait behind queued ones so they keep their order
+func (s *spool) SaveMsg(id int64, msgId int) error {
+	p := pendingWrite{Table: TableMsg, Values: []interface{}{id, msgId, time.Now().Unix()}}
+	if s.Pending() > 0 {
+		return s.add(p)
+	}
+	err := s.sheetsSrv.SaveMsg(id, msgId)
+	if offline(err) {
+		fmt.Printf("spool: SaveMsg: %v\n", err)
+		return s.add(p)
+	}
+	return err
+}
+
+func (s *spool) SaveHistory(msgs ...Message) error {
+	if len(msgs) == 0 || s.history.id == "" {
+		return nil
+	}
+	if s.Pending() == 0 {
+		err := s.sheetsSrv.SaveHistory(msgs...)
+		if !offline(err) {
+			return err
+		}
+		fmt.Printf("spool: SaveHistory: %v\n", err)
+	}
+	ps := make([]pendingWrite, 0, len(msgs))
+	for _, m := range msgs {
+		ps = append(ps, pendingWrite{Table: TableHistory, Values: historyRow(m)})
+	}
+	return s.add(ps...)
+}
+
+//queue writes and persist the queue
+func (s *spool) add(ps ...pendingWrite) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, ps...)
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *spool) save() error {
+	s.fileMu.Lock()
+	defer s.fileMu.Unlock()
+	s.mu.Lock()
+	pending := append([]pendingWrite(nil), s.pending...)
+	s.mu.Unlock()
+	return writeQueue(s.file, pending)
+}
+
+//sheets was not reached or answered with a quota or server error: the
+//breaker is open, the network is down or google failed
+func offline(err error) bool {
+	if err == nil {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == 429 || apiErr.Code >= 500
+	}
+	return false
+}
+
+//user id, message id and unix time of a queued msg write
+func spooledMsg(values []interface{}) (int64, int, int64, error) {
+	id, err := strconv.ParseInt(cell(values, 0), 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "id")
+	}
+	msgId, err := strconv.Atoi(cell(values, 1))
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "msg id")
+	}
+	since, err := strconv.ParseInt(cell(values, 2), 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.Wrap(err, "since")
+	}
+	return id, msgId, since, nil
+}
+
+//queue left in file, empty if there is no file
+func readQueue(file string) ([]pendingWrite, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	//keep ids as numbers instead of float64
+	dec.UseNumber()
+	var pending []pendingWrite
+	err = dec.Decode(&pending)
+	if err != nil {
+		return nil, errors.Wrap(err, "Decode")
+	}
+	return pending, nil
+}
+
+func writeQueue(file string, pending []pendingWrite) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func testSpool(t *testing.T, srv *sheets.Service) *spool {
+	s, err := NewSpool(&sheetsSrv{
+		msgMu:   &sync.Mutex{},
+		indexes: newIndexCache(),
+		srv:     srv,
+		msg:     &table{name: TableMsg, id: "doc", tab: defaultTab, cols: []int{0, 1, 2}, width: 3},
+		history: &table{name: TableHistory, id: "doc", tab: "history", cols: []int{0, 1, 2, 3, 4, 5, 6, 7}, width: 8},
+	}, filepath.Join(t.TempDir(), "queue.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSpoolReplays(t *testing.T) {
+	status := int32(http.StatusServiceUnavailable)
+	s := testSpool(t, fakeSheets(t, &status))
+	if err := s.SaveMsg(1, 10); err != nil {
+		t.Fatalf("SaveMsg: %v", err)
+	}
+	if err := s.SaveHistory(Message{UserId: 1, MsgId: 10, Direction: DirIn, Text: "hi"}); err != nil {
+		t.Fatalf("SaveHistory: %v", err)
+	}
+	if n := s.Pending(); n != 2 {
+		t.Fatalf("pending = %v, want 2", n)
+	}
+	if n := len(queuedOnDisk(t, s.file)); n != 2 {
+		t.Fatalf("queued on disk = %v, want 2", n)
+	}
+
+	if err := s.Flush(); err == nil {
+		t.Fatal("Flush while sheets is down: no error")
+	}
+	if n := s.Pending(); n != 2 {
+		t.Fatalf("pending after failed replay = %v, want 2", n)
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if n := s.Pending(); n != 0 {
+		t.Fatalf("pending after replay = %v, want 0", n)
+	}
+	if n := len(queuedOnDisk(t, s.file)); n != 0 {
+		t.Fatalf("queued on disk after replay = %v, want 0", n)
+	}
+}
+
+func TestSpoolKeepsOtherErrors(t *testing.T) {
+	status := int32(http.StatusForbidden)
+	s := testSpool(t, fakeSheets(t, &status))
+	if err := s.SaveMsg(1, 10); err == nil {
+		t.Fatal("SaveMsg: no error")
+	}
+	if n := s.Pending(); n != 0 {
+		t.Fatalf("pending = %v, want 0", n)
+	}
+}
+
+//write-behind takes over writes kept by the spool
+func TestRestoreSpooled(t *testing.T) {
+	w := testWriteBehind(t, nil)
+	msg := &table{name: TableMsg, id: "doc", tab: "msg", cols: []int{0, 1, 2}, width: 3}
+	w.tables[TableMsg] = &memTable{table: msg, index: newRowIndex(nil, 0, keyInt, 0)}
+	queue := `[
+		{"table":"msg","row":0,"values":[42,7,1600000000]},
+		{"table":"msg","row":0,"values":[42,8,1600000100]}
+	]`
+	if err := ioutil.WriteFile(w.file, []byte(queue), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.restore(); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := w.GetPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Id != 42 || len(pending[0].MsgIds) != 2 {
+		t.Fatalf("pending = %+v, want user 42 with 2 messages", pending)
+	}
+	if !pending[0].Since.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("since = %v, want the first message", pending[0].Since)
+	}
+	for _, p := range queuedOnDisk(t, w.file) {
+		if p.Row == 0 {
+			t.Fatalf("queue on disk still has spooled write %+v", p)
+		}
+	}
+}
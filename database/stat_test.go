@@ -0,0 +1,25 @@
+package database
+
+import "testing"
+
+func TestGetStatMessages(t *testing.T) {
+	srv := fakeValues(t, [][]interface{}{
+		{"1", "5", "1600000000"},
+		//cleared row
+		{"", "", ""},
+		{"not an id", "6", ""},
+		{"2", "7,6", "1600000000"},
+	})
+	s := &sheetsSrv{
+		srv:   srv,
+		users: &table{name: TableUsers, id: "doc", tab: defaultTab, cols: []int{0, 1, 2, 3, 4, 5}, width: 6},
+		msg:   &table{name: TableMsg, id: "doc", tab: defaultTab, cols: []int{0, 1, 2}, width: 3},
+	}
+	stat, err := s.GetStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat["messages"] != 2 {
+		t.Errorf("messages = %v, want 2", stat["messages"])
+	}
+}
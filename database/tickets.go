@@ -0,0 +1,52 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+//conversation: user messages and the replies to them
+type Ticket struct {
+	UserId     int64
+	Num        int
+	Messages   []Message
+	Start      time.Time
+	End        time.Time
+	FirstReply time.Time
+	Open       bool
+}
+
+func (t Ticket) Id() string {
+	return fmt.Sprintf("%v-%v", t.UserId, t.Num)
+}
+
+//split history of one user into tickets: a ticket starts with a user message
+//after a reply, outbound messages before the first user message are skipped.
+//Broadcasts are not part of any ticket, they neither answer nor close it
+func SplitTickets(msgs []Message) []Ticket {
+	out := make([]Ticket, 0)
+	var cur *Ticket
+	for _, m := range msgs {
+		if m.Direction == DirBroadcast {
+			continue
+		}
+		if m.Direction == DirIn && (cur == nil || !cur.Open) {
+			out = append(out, Ticket{
+				UserId: m.UserId,
+				Num:    len(out) + 1,
+				Start:  m.Time,
+			})
+			cur = &out[len(out)-1]
+		}
+		if cur == nil {
+			continue
+		}
+		cur.Messages = append(cur.Messages, m)
+		cur.End = m.Time
+		cur.Open = m.Direction == DirIn
+		if m.Direction == DirOut && cur.FirstReply.IsZero() {
+			cur.FirstReply = m.Time
+		}
+	}
+	return out
+}
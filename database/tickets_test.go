@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSplitTickets(t *testing.T) {
+	at := func(min int) time.Time { return time.Date(2024, 1, 1, 10, min, 0, 0, time.UTC) }
+	msg := func(dir string, min int) Message { return Message{UserId: 7, Direction: dir, Time: at(min)} }
+
+	type ticket struct {
+		start, end, firstReply int
+		messages               int
+		open                   bool
+	}
+	tests := []struct {
+		name string
+		msgs []Message
+		want []ticket
+	}{
+		{"empty", nil, nil},
+		{"reply before first message", []Message{msg(DirOut, 0)}, nil},
+		{"one open", []Message{msg(DirIn, 0), msg(DirIn, 1)}, []ticket{{0, 1, -1, 2, true}}},
+		{"answered", []Message{msg(DirIn, 0), msg(DirOut, 5), msg(DirOut, 6)}, []ticket{{0, 6, 5, 3, false}}},
+		{"second after reply", []Message{msg(DirIn, 0), msg(DirOut, 5), msg(DirIn, 9), msg(DirOut, 12)},
+			[]ticket{{0, 5, 5, 2, false}, {9, 12, 12, 2, false}}},
+		{"broadcast does not answer", []Message{msg(DirIn, 0), msg(DirBroadcast, 3), msg(DirIn, 4)},
+			[]ticket{{0, 4, -1, 2, true}}},
+		{"broadcast does not close", []Message{msg(DirIn, 0), msg(DirBroadcast, 3), msg(DirOut, 8)},
+			[]ticket{{0, 8, 8, 2, false}}},
+		{"only broadcasts", []Message{msg(DirBroadcast, 0), msg(DirBroadcast, 1)}, nil},
+	}
+	for _, tt := range tests {
+		got := SplitTickets(tt.msgs)
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: %v tickets, want %v", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			g := got[i]
+			firstReply := time.Time{}
+			if w.firstReply >= 0 {
+				firstReply = at(w.firstReply)
+			}
+			if g.Num != i+1 || !g.Start.Equal(at(w.start)) || !g.End.Equal(at(w.end)) ||
+				!g.FirstReply.Equal(firstReply) || len(g.Messages) != w.messages || g.Open != w.open {
+				t.Errorf("%v: ticket %v = %+v, want %+v", tt.name, i, g, w)
+			}
+		}
+	}
+}
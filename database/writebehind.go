@@ -0,0 +1,547 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	//rows added to a sheet when writes go past its grid
+	gridGrowth = 500
+	maxBackoff = 5 * time.Minute
+)
+
+//copy of a table kept in memory as field values, rows are numbered from 1
+//like in sheets
+type memTable struct {
+	*table
+	rows [][]interface{}
+	//rows by user id
+	index *rowIndex
+}
+
+//row write waiting to be sent to sheets
+type pendingWrite struct {
+	Table  string        `json:"table"`
+	Row    int           `json:"row"`
+	Values []interface{} `json:"values"`
+}
+
+//write-behind layer in front of sheetsSrv: users, msg and history are served
+//from memory, writes are batched into BatchUpdate calls every interval and the
+//queue is kept in a local file until sheets accepts it. The bot has to be the
+//only writer of these sheets.
+type writeBehind struct {
+	*sheetsSrv
+
+	mu       sync.Mutex
+	tables   map[string]*memTable
+	pending  []pendingWrite
+	file     string
+	interval time.Duration
+	backoff  time.Duration
+	flushMu  sync.Mutex
+	fileMu   sync.Mutex
+}
+
+func NewWriteBehind(s *sheetsSrv, file string, interval time.Duration) (*writeBehind, error) {
+	w := &writeBehind{
+		sheetsSrv: s,
+		tables: map[string]*memTable{
+			TableUsers:   {table: s.users},
+			TableMsg:     {table: s.msg},
+			TableHistory: {table: s.history},
+		},
+		file:     file,
+		interval: interval,
+	}
+	for name, t := range w.tables {
+		//optional table that is not configured
+		if t.id == "" {
+			t.index = newRowIndex(nil, 0, keyInt, 0)
+			continue
+		}
+		err := w.load(t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load %v", name)
+		}
+	}
+	err := w.restore()
+	if err != nil {
+		return nil, errors.Wrap(err, "restore")
+	}
+	return w, nil
+}
+
+func (w *writeBehind) load(t *memTable) error {
+	rows, err := w.rows(t.table)
+	if err != nil {
+		return errors.Wrap(err, "Get")
+	}
+	t.rows = rows
+	t.index = newRowIndex(t.rows, 0, keyInt, 0)
+	return nil
+}
+
+//apply writes left from the previous run, they stay in the queue. Writes
+//kept by the spool without write-behind are applied like new ones
+func (w *writeBehind) restore() error {
+	pending, err := readQueue(w.file)
+	if err != nil {
+		return err
+	}
+	spooled := false
+	for _, p := range pending {
+		if p.Row > 0 {
+			w.set(p.Table, p.Row, p.Values)
+			continue
+		}
+		spooled = true
+		switch p.Table {
+		case TableMsg:
+			id, msgId, since, err := spooledMsg(p.Values)
+			if err != nil {
+				return errors.Wrap(err, "spooledMsg")
+			}
+			row, values, err := w.msgRow(id, msgId, since)
+			if err != nil {
+				return errors.Wrap(err, "msgRow")
+			}
+			w.set(TableMsg, row, values)
+		case TableHistory:
+			w.set(TableHistory, len(w.tables[TableHistory].rows)+1, p.Values)
+		}
+	}
+	if spooled {
+		return w.save()
+	}
+	return nil
+}
+
+//writes waiting to be sent
+func (w *writeBehind) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending)
+}
+
+//flush every interval until ctx is done, then flush the rest
+func (w *writeBehind) Run(ctx context.Context) {
+	timer := time.NewTimer(w.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := w.Flush()
+			if err != nil {
+				fmt.Printf("Flush: %v\n", err)
+			}
+			return
+		case <-timer.C:
+			err := w.Flush()
+			next := w.interval
+			if err != nil {
+				fmt.Printf("Flush: %v\n", err)
+				next = w.nextBackoff(err)
+			} else {
+				w.backoff = 0
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+//doubled wait after quota and server errors, other errors are retried
+//every interval until the sheet is fixed
+func (w *writeBehind) nextBackoff(err error) time.Duration {
+	if !retryable(err) {
+		return w.interval
+	}
+	if w.backoff == 0 {
+		w.backoff = w.interval
+	}
+	w.backoff *= 2
+	if w.backoff > maxBackoff {
+		w.backoff = maxBackoff
+	}
+	return w.backoff
+}
+
+func retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		//network errors
+		return true
+	}
+	return apiErr.Code == 429 || apiErr.Code >= 500
+}
+
+//send queued writes, one BatchUpdate per spreadsheet. Writes to a
+//spreadsheet that failed stay in the queue whatever the error, memory
+//already has these rows and dropping them would leave sheets behind for
+//good. The other spreadsheets are still written
+func (w *writeBehind) Flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	grow := make(map[string]*sheets.Request)
+	for _, p := range batch {
+		t := w.tables[p.Table]
+		if int64(p.Row) <= t.gridRows {
+			continue
+		}
+		length := int64(p.Row) - t.gridRows + gridGrowth
+		grow[p.Table] = &sheets.Request{AppendDimension: &sheets.AppendDimensionRequest{
+			SheetId:   t.sheetId,
+			Dimension: "ROWS",
+			Length:    length,
+		}}
+		t.gridRows += length
+	}
+	w.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	failed, err := w.send(batch, grow)
+	if len(failed) > 0 {
+		w.mu.Lock()
+		w.requeue(failed)
+		//grids of the failed spreadsheets were not grown
+		for table := range grow {
+			w.tables[table].gridRows -= grow[table].AppendDimension.Length
+		}
+		w.mu.Unlock()
+	}
+	saveErr := w.save()
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
+	return saveErr
+}
+
+//write the batch spreadsheet by spreadsheet, returns the writes of the
+//spreadsheets that failed in queue order and the first error. Grown grids
+//are removed from grow
+func (w *writeBehind) send(batch []pendingWrite, grow map[string]*sheets.Request) ([]pendingWrite, error) {
+	ids := make([]string, 0)
+	data := make(map[string][]*sheets.ValueRange)
+	for _, p := range batch {
+		t := w.tables[p.Table]
+		if _, ok := data[t.id]; !ok {
+			ids = append(ids, t.id)
+		}
+		data[t.id] = append(data[t.id], &sheets.ValueRange{
+			MajorDimension: "ROWS",
+			Range:          t.rowRange(p.Row),
+			Values:         [][]interface{}{t.toSheet(p.Values)},
+		})
+	}
+
+	bad := make(map[string]bool)
+	var firstErr error
+	for _, id := range ids {
+		err := w.sendSpreadsheet(id, data[id], grow)
+		if err != nil {
+			bad[id] = true
+			if firstErr == nil {
+				firstErr = errors.Wrap(err, id)
+			}
+		}
+	}
+	failed := make([]pendingWrite, 0)
+	for _, p := range batch {
+		if bad[w.tables[p.Table].id] {
+			failed = append(failed, p)
+		}
+	}
+	return failed, firstErr
+}
+
+func (w *writeBehind) sendSpreadsheet(id string, ranges []*sheets.ValueRange, grow map[string]*sheets.Request) error {
+	for table, req := range grow {
+		if w.tables[table].id != id {
+			continue
+		}
+		_, err := w.srv.Spreadsheets.BatchUpdate(id, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: []*sheets.Request{req},
+		}).Do()
+		if err != nil {
+			return errors.Wrap(err, "AppendDimension")
+		}
+		delete(grow, table)
+	}
+	_, err := w.srv.Spreadsheets.Values.BatchUpdate(id, &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "RAW",
+		Data:             ranges,
+	}).Do()
+	if err != nil {
+		return errors.Wrap(err, "BatchUpdate")
+	}
+	return nil
+}
+
+//put a failed batch back in front of writes queued since
+func (w *writeBehind) requeue(batch []pendingWrite) {
+	newer := w.pending
+	w.pending = batch
+	for _, p := range newer {
+		w.enqueue(p)
+	}
+}
+
+//keep the queue on disk so it survives a restart
+func (w *writeBehind) save() error {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	w.mu.Lock()
+	pending := append([]pendingWrite(nil), w.pending...)
+	w.mu.Unlock()
+	return writeQueue(w.file, pending)
+}
+
+//later writes of the same row replace earlier ones
+func (w *writeBehind) enqueue(p pendingWrite) {
+	for i := range w.pending {
+		if w.pending[i].Table == p.Table && w.pending[i].Row == p.Row {
+			w.pending[i].Values = p.Values
+			return
+		}
+	}
+	w.pending = append(w.pending, p)
+}
+
+//change a row in memory and queue it, caller holds mu. Tables that are
+//not configured are skipped, also in a queue left by a run that had them
+func (w *writeBehind) set(table string, row int, values []interface{}) {
+	t := w.tables[table]
+	if t == nil || t.id == "" {
+		return
+	}
+	for len(t.rows) < row {
+		t.rows = append(t.rows, []interface{}{})
+	}
+	t.index.Remove(row, cell(t.rows[row-1], 0))
+	t.rows[row-1] = values
+	t.index.Set(row, cell(values, 0))
+	w.enqueue(pendingWrite{Table: table, Row: row, Values: values})
+}
+
+//set and persist the queue
+func (w *writeBehind) write(table string, row int, values []interface{}) error {
+	w.set(table, row, values)
+	w.mu.Unlock()
+	err := w.save()
+	w.mu.Lock()
+	return err
+}
+
+func (w *writeBehind) SaveMsg(id int64, msgId int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	row, values, err := w.msgRow(id, msgId, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return w.write(TableMsg, row, values)
+}
+
+//msg row of user id with msgId added, caller holds mu
+func (w *writeBehind) msgRow(id int64, msgId int, since int64) (int, []interface{}, error) {
+	t := w.tables[TableMsg]
+	row, err := t.index.One(id)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "One")
+	}
+	if row == 0 {
+		return len(t.rows) + 1, []interface{}{id, msgId, since}, nil
+	}
+	old := t.rows[row-1]
+	//keep the time of the first unanswered message
+	var first interface{} = since
+	if s := cell(old, 2); s != "" {
+		first = s
+	}
+	return row, []interface{}{id, strconv.Itoa(msgId) + "," + cell(old, 1), first}, nil
+}
+
+func (w *writeBehind) GetPending() ([]Pending, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Pending, 0)
+	for _, row := range w.tables[TableMsg].rows {
+		if p, ok := parsePending(row); ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (w *writeBehind) ClearPending(id int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, row := range w.tables[TableMsg].index.Find(id) {
+		err := w.write(TableMsg, row, w.tables[TableMsg].blank())
+		if err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
+	return nil
+}
+
+//the user who waits the longest, the row stays until ClearPending
+func (w *writeBehind) GetLast() (int64, []int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	row := 0
+	var oldest Pending
+	for i, values := range w.tables[TableMsg].rows {
+		p, ok := parsePending(values)
+		if !ok || len(p.MsgIds) == 0 {
+			continue
+		}
+		if row == 0 || p.Since.Before(oldest.Since) {
+			row, oldest = i+1, p
+		}
+	}
+	if row == 0 {
+		return 0, nil, ErrNoRows
+	}
+	return oldest.Id, oldest.MsgIds, nil
+}
+
+func (w *writeBehind) SaveContact(id int64, name, nick string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableUsers]
+	if len(t.index.Find(id)) > 0 {
+		return errors.New("duplicate")
+	}
+	return w.write(TableUsers, len(t.rows)+1, []interface{}{id, name, nick, "", time.Now().Unix()})
+}
+
+func (w *writeBehind) SaveRegion(id int64, region string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableUsers]
+	rows := t.index.Find(id)
+	if len(rows) != 1 {
+		return errors.New("contact not found")
+	}
+	row := rows[0]
+	values := make([]interface{}, len(t.cols))
+	for i := range values {
+		values[i] = cell(t.rows[row-1], i)
+	}
+	values[3] = region
+	return w.write(TableUsers, row, values)
+}
+
+func (w *writeBehind) SetBlocked(id int64, blocked bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableUsers]
+	if t.cols[5] < 0 {
+		return errors.New("no blocked column")
+	}
+	rows := t.index.Find(id)
+	if len(rows) != 1 {
+		return errors.New("contact not found")
+	}
+	row := rows[0]
+	values := make([]interface{}, len(t.cols))
+	for i := range values {
+		values[i] = cell(t.rows[row-1], i)
+	}
+	values[5] = ""
+	if blocked {
+		values[5] = time.Now().Unix()
+	}
+	return w.write(TableUsers, row, values)
+}
+
+func (w *writeBehind) GetAll() ([]int64, error) {
+	contacts, err := w.GetContacts()
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, ErrNoRows
+	}
+	out := make([]int64, 0, len(contacts))
+	for _, c := range contacts {
+		out = append(out, c.Id)
+	}
+	return out, nil
+}
+
+func (w *writeBehind) GetContacts() ([]Contact, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Contact, 0)
+	for _, row := range w.tables[TableUsers].rows {
+		if c, ok := parseContact(row); ok {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (w *writeBehind) GetContact(id int64) (*Contact, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableUsers]
+	rows := t.index.Find(id)
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	c, ok := parseContact(t.rows[rows[0]-1])
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+//without the history table messages are not kept
+func (w *writeBehind) SaveHistory(msgs ...Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableHistory]
+	if len(msgs) == 0 || t.id == "" {
+		return nil
+	}
+	for _, m := range msgs {
+		w.set(TableHistory, len(t.rows)+1, historyRow(m))
+	}
+	w.mu.Unlock()
+	err := w.save()
+	w.mu.Lock()
+	return err
+}
+
+func (w *writeBehind) GetAllHistory() ([]Message, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return parseHistory(w.tables[TableHistory].rows), nil
+}
+
+func (w *writeBehind) GetHistory(userId int64) ([]Message, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t := w.tables[TableHistory]
+	rows := make([][]interface{}, 0)
+	for _, row := range t.index.Find(userId) {
+		rows = append(rows, t.rows[row-1])
+	}
+	return parseHistory(rows), nil
+}
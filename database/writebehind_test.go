@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+//sheets api answering every call with the status in code
+func fakeSheets(t *testing.T, code *int32) *sheets.Service {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := int(atomic.LoadInt32(code))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(c)
+		if c != http.StatusOK {
+			fmt.Fprintf(w, `{"error":{"code":%d,"message":"fail"}}`, c)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(ts.Close)
+	srv, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(ts.URL+"/"),
+		option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func testWriteBehind(t *testing.T, srv *sheets.Service) *writeBehind {
+	users := &table{name: TableUsers, id: "doc", tab: defaultTab, gridRows: 1000, cols: []int{0, 1, 2, 3, 4, 5}, width: 6}
+	return &writeBehind{
+		sheetsSrv: &sheetsSrv{srv: srv, users: users},
+		tables: map[string]*memTable{
+			TableUsers: {table: users, index: newRowIndex(nil, 0, keyInt, 0)},
+		},
+		file: filepath.Join(t.TempDir(), "queue.json"),
+	}
+}
+
+func queuedOnDisk(t *testing.T, file string) []pendingWrite {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []pendingWrite
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestFlushKeepsFailedBatch(t *testing.T) {
+	for _, code := range []int32{400, 403, 404, 429, 500, 503} {
+		code := code
+		t.Run(http.StatusText(int(code)), func(t *testing.T) {
+			status := code
+			w := testWriteBehind(t, fakeSheets(t, &status))
+			if err := w.SaveContact(1, "name", "nick"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SaveContact(2, "name", "nick"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := w.Flush(); err == nil {
+				t.Fatal("Flush: no error")
+			}
+			if n := w.Pending(); n != 2 {
+				t.Fatalf("pending after error = %v, want 2", n)
+			}
+			if n := len(queuedOnDisk(t, w.file)); n != 2 {
+				t.Fatalf("queued on disk after error = %v, want 2", n)
+			}
+
+			atomic.StoreInt32(&status, http.StatusOK)
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			if n := w.Pending(); n != 0 {
+				t.Fatalf("pending after success = %v, want 0", n)
+			}
+			if n := len(queuedOnDisk(t, w.file)); n != 0 {
+				t.Fatalf("queued on disk after success = %v, want 0", n)
+			}
+		})
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	w := &writeBehind{}
+	w.pending = []pendingWrite{
+		{Table: TableUsers, Row: 1, Values: []interface{}{"new"}},
+		{Table: TableUsers, Row: 3, Values: []interface{}{"c"}},
+	}
+	w.requeue([]pendingWrite{
+		{Table: TableUsers, Row: 1, Values: []interface{}{"old"}},
+		{Table: TableUsers, Row: 2, Values: []interface{}{"b"}},
+	})
+	want := []struct {
+		row   int
+		value string
+	}{{1, "new"}, {2, "b"}, {3, "c"}}
+	if len(w.pending) != len(want) {
+		t.Fatalf("pending = %v, want %v writes", w.pending, len(want))
+	}
+	for i, p := range w.pending {
+		if p.Row != want[i].row || p.Values[0] != want[i].value {
+			t.Errorf("pending[%v] = row %v %v, want row %v %v", i, p.Row, p.Values[0], want[i].row, want[i].value)
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	w := testWriteBehind(t, nil)
+	queue := `[
+		{"table":"users","row":1,"values":[12345678901,"a","nick_a","","1600000000",""]},
+		{"table":"users","row":2,"values":[42,"b","nick_b","region","1600000001",""]}
+	]`
+	if err := ioutil.WriteFile(w.file, []byte(queue), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.restore(); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.Pending(); n != 2 {
+		t.Fatalf("pending = %v, want 2", n)
+	}
+	tests := []struct {
+		id     int64
+		region string
+	}{{12345678901, ""}, {42, "region"}}
+	for _, tt := range tests {
+		c, err := w.GetContact(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c == nil {
+			t.Fatalf("contact %v not restored", tt.id)
+		}
+		if c.Region != tt.region {
+			t.Errorf("contact %v region = %q, want %q", tt.id, c.Region, tt.region)
+		}
+	}
+}
+
+func TestRestoreWithoutFile(t *testing.T) {
+	w := testWriteBehind(t, nil)
+	if err := w.restore(); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.Pending(); n != 0 {
+		t.Fatalf("pending = %v, want 0", n)
+	}
+}
+
+func TestUnconfiguredHistory(t *testing.T) {
+	status := int32(http.StatusOK)
+	w := testWriteBehind(t, fakeSheets(t, &status))
+	history := &table{name: TableHistory, tab: defaultTab, cols: []int{0, 1, 2, 3, 4, 5, 6, 7}, width: 8}
+	w.tables[TableHistory] = &memTable{table: history, index: newRowIndex(nil, 0, keyInt, 0)}
+
+	if err := w.SaveHistory(Message{UserId: 1, MsgId: 2, Direction: DirIn, Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SaveContact(1, "name", "nick"); err != nil {
+		t.Fatal(err)
+	}
+	//a queue of a run that had the table
+	w.set(TableHistory, 1, []interface{}{1, 3, DirIn, "", "", "", "old", 1600000000})
+	if n := w.Pending(); n != 1 {
+		t.Fatalf("pending = %v, want only the contact", n)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := w.Pending(); n != 0 {
+		t.Fatalf("pending after Flush = %v, want 0", n)
+	}
+}
+
+func TestFlushIsolatesSpreadsheet(t *testing.T) {
+	hits := make(map[string]int)
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(r.URL.Path, "/broken/") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":{"code":403,"message":"fail"}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	srv, err := sheets.NewService(context.Background(),
+		option.WithEndpoint(ts.URL+"/"),
+		option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := testWriteBehind(t, srv)
+	msg := &table{name: TableMsg, id: "broken", tab: defaultTab, gridRows: 1000, cols: []int{0, 1, 2}, width: 3}
+	w.tables[TableMsg] = &memTable{table: msg, index: newRowIndex(nil, 0, keyInt, 0)}
+
+	for i := 0; i < 2; i++ {
+		if err := w.SaveContact(int64(i+1), "name", "nick"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SaveMsg(int64(i+1), 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err == nil {
+			t.Fatal("Flush: no error from the broken spreadsheet")
+		}
+		if n := w.Pending(); n != i+1 {
+			t.Fatalf("pending = %v, want %v msg rows", n, i+1)
+		}
+		for _, p := range queuedOnDisk(t, w.file) {
+			if p.Table != TableMsg {
+				t.Fatalf("%v write left in the queue", p.Table)
+			}
+		}
+	}
+	if n := hits["/v4/spreadsheets/doc/values:batchUpdate"]; n != 2 {
+		t.Errorf("users written %v times, want 2", n)
+	}
+}
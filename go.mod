@@ -8,5 +8,8 @@ require (
 	github.com/jmoiron/sqlx v1.3.4 // indirect
 	github.com/joho/godotenv v1.4.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/image v0.18.0
 	google.golang.org/api v0.67.0
 )
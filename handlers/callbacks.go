@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+//inline keyboard buttons pressed by admins
+func (h *handler) Callback(q *tgbotapi.CallbackQuery) error {
+	_, err := h.bot.Request(tgbotapi.NewCallback(q.ID, ""))
+	if err != nil {
+		return errors.Wrap(err, "Request")
+	}
+	if q.Message == nil {
+		return nil
+	}
+	chatId := q.Message.Chat.ID
+
+	switch {
+	case strings.HasPrefix(q.Data, cannedCallback):
+		if q.Message.ReplyToMessage == nil {
+			return h.send(chatId, cannedNoReply)
+		}
+		name := strings.TrimPrefix(q.Data, cannedCallback)
+		err := h.ReplyCanned(q.Message.ReplyToMessage, name, chatId, q.From.UserName)
+		if err != nil {
+			return errors.Wrap(err, "ReplyCanned")
+		}
+		//drop the keyboard so the reply is not sent twice
+		_, err = h.bot.Request(tgbotapi.NewDeleteMessage(chatId, q.Message.MessageID))
+		if err != nil {
+			return errors.Wrap(err, "Request")
+		}
+	case strings.HasPrefix(q.Data, profileCallback):
+		err := h.profileCallback(q)
+		if err != nil {
+			return errors.Wrap(err, "profileCallback")
+		}
+	case strings.HasPrefix(q.Data, historyCallback):
+		err := h.historyCallback(q)
+		if err != nil {
+			return errors.Wrap(err, "historyCallback")
+		}
+	}
+	return nil
+}
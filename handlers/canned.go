@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	cannedHelp = `/canned add (name) (text) - сохранить шаблон ответа
+/canned del (name) - удалить шаблон
+/canned list - список шаблонов
+/canned - в ответ на сообщение: выбрать шаблон кнопкой
+/r (name) - в ответ на сообщение: ответить шаблоном
+переменные: {name}, {first_name}, {nick}, {region}`
+
+	cannedOk      = "шаблон сохранён"
+	cannedDeleted = "шаблон удалён"
+	cannedEmpty   = "шаблонов пока нет"
+	cannedUnknown = "шаблон не найден"
+	cannedNoReply = "команду нужно отправить ответом на сообщение пользователя"
+	cannedLong    = "имя шаблона длиннее %v байт не помещается в кнопку"
+
+	cannedCallback = "canned:"
+	//telegram limit of callback data
+	callbackLimit = 64
+	//longest name that fits a button
+	cannedNameLimit = callbackLimit - len(cannedCallback)
+)
+
+//canned subcommands: add, del, list; keyboard when replying to a message
+func (h *handler) Canned(id int64, args string, reply *tgbotapi.Message) error {
+	fields := splitArgs(args, 3)
+	if len(fields) == 0 {
+		if reply != nil {
+			return h.cannedKeyboard(id, reply.MessageID)
+		}
+		return h.ListCanned(id)
+	}
+	switch fields[0] {
+	case "add":
+		if len(fields) < 3 {
+			return h.send(id, cannedHelp)
+		}
+		return h.AddCanned(id, fields[1], fields[2])
+	case "del":
+		if len(fields) < 2 {
+			return h.send(id, cannedHelp)
+		}
+		return h.DeleteCanned(id, fields[1])
+	case "list":
+		return h.ListCanned(id)
+	default:
+		return h.send(id, cannedHelp)
+	}
+}
+
+func (h *handler) AddCanned(id int64, name, text string) error {
+	if len(name) > cannedNameLimit {
+		return h.send(id, fmt.Sprintf(cannedLong, cannedNameLimit))
+	}
+	err := h.storage.SaveCanned(name, text)
+	if err == database.ErrDisabled {
+		return h.sendDisabled(id, database.TableCanned)
+	}
+	if err != nil {
+		return errors.Wrap(err, "SaveCanned")
+	}
+	h.mu.Lock()
+	h.canned[name] = text
+	h.mu.Unlock()
+	return h.send(id, cannedOk)
+}
+
+func (h *handler) DeleteCanned(id int64, name string) error {
+	if _, ok := h.cannedText(name); !ok {
+		return h.send(id, cannedUnknown)
+	}
+	err := h.storage.DeleteCanned(name)
+	if err == database.ErrDisabled {
+		return h.sendDisabled(id, database.TableCanned)
+	}
+	if err != nil {
+		return errors.Wrap(err, "DeleteCanned")
+	}
+	h.mu.Lock()
+	delete(h.canned, name)
+	h.mu.Unlock()
+	return h.send(id, cannedDeleted)
+}
+
+func (h *handler) ListCanned(id int64) error {
+	names := h.cannedNames()
+	if len(names) == 0 {
+		return h.send(id, cannedEmpty)
+	}
+	text := ""
+	for _, name := range names {
+		if t, ok := h.cannedText(name); ok {
+			text += fmt.Sprintf("%v: %v\n", name, t)
+		}
+	}
+	return h.send(id, text)
+}
+
+//answer to forwarded message with a saved reply
+func (h *handler) ReplyCanned(reply *tgbotapi.Message, name string, chatId int64, admin string) error {
+	if reply == nil {
+		return h.send(chatId, cannedNoReply)
+	}
+	text, ok := h.cannedText(name)
+	if !ok {
+		return h.send(chatId, cannedUnknown)
+	}
+	userId, err := h.replyUser(chatId, reply)
+	if err != nil {
+		return errors.Wrap(err, "replyUser")
+	}
+	if userId == 0 {
+		return h.send(chatId, replyUnknown)
+	}
+	text, err = h.fillCanned(userId, text)
+	if err != nil {
+		return errors.Wrap(err, "fillCanned")
+	}
+	return h.ReplyToMsg(reply, text, chatId, admin)
+}
+
+//substitute contact info into a template
+func (h *handler) fillCanned(userId int64, text string) (string, error) {
+	contact, err := h.storage.GetContact(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "GetContact")
+	}
+	var name, firstName, nick, region string
+	if contact != nil {
+		name, nick, region = contact.Name, contact.Nick, contact.Region
+		firstName = strings.Fields(name + " ")[0]
+	}
+	return strings.NewReplacer(
+		"{name}", strings.TrimSpace(name),
+		"{first_name}", firstName,
+		"{nick}", nick,
+		"{region}", region,
+	).Replace(text), nil
+}
+
+func (h *handler) cannedKeyboard(id int64, replyTo int) error {
+	names := h.cannedNames()
+	if len(names) == 0 {
+		return h.send(id, cannedEmpty)
+	}
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(names))
+	for _, name := range names {
+		//long names from the sheet are left for /r
+		if len(name) > cannedNameLimit {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(name, cannedCallback+name)))
+	}
+	if len(rows) == 0 {
+		return h.send(id, fmt.Sprintf(cannedLong, cannedNameLimit))
+	}
+	msg := tgbotapi.NewMessage(id, "выберите шаблон")
+	msg.ReplyToMessageID = replyTo
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err := h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+func (h *handler) cannedText(name string) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	text, ok := h.canned[name]
+	return text, ok
+}
+
+func (h *handler) cannedNames() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.canned))
+	for name := range h.canned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+	"github.com/CookieNyanCloud/tg-connection-base/stats"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	digestDailyTxt  = "сводка за сутки"
+	digestWeeklyTxt = "сводка за неделю"
+	broadcastTxt    = "%v @%v: доставлено %v, заблокировали %v, ошибок %v\n"
+	digestMoreTxt   = "…и ещё %v\n"
+
+	//telegram limit of a message text
+	messageLimit = 4096
+)
+
+//send the digest on schedule until ctx is done, a digest missed while the
+//bot was down is not sent later
+func (h *handler) RunDigest(ctx context.Context) {
+	if len(h.digest.To) == 0 {
+		return
+	}
+	for {
+		next := h.nextDigest(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			err := h.sendDigest(now)
+			if err != nil {
+				fmt.Printf("sendDigest: %v\n", err)
+			}
+		}
+	}
+}
+
+//first digest time after now
+func (h *handler) nextDigest(now time.Time) time.Time {
+	local := now.In(h.hours.loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, h.hours.loc)
+	for {
+		at := day.Add(h.digest.At)
+		if at.After(now) && (h.digest.Every == config.DigestDaily || day.Weekday() == time.Monday) {
+			return at
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+}
+
+func (h *handler) sendDigest(now time.Time) error {
+	text, err := h.digestText(now)
+	if err != nil {
+		return errors.Wrap(err, "digestText")
+	}
+	h.mu.RLock()
+	to := make([]int64, 0, len(h.digest.To))
+	for _, nick := range h.digest.To {
+		if admin, ok := h.admins[nick]; ok && admin.ChatId != 0 {
+			to = append(to, admin.ChatId)
+		}
+	}
+	h.mu.RUnlock()
+
+	//the rest still get the digest
+	var sendErr error
+	for _, id := range to {
+		msg := tgbotapi.NewMessage(id, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		_, err := h.bot.Send(msg)
+		if err != nil && sendErr == nil {
+			sendErr = errors.Wrapf(err, "Send %v", id)
+		}
+	}
+	return sendErr
+}
+
+//summary of the period, unanswered users, sla breaches and broadcasts
+func (h *handler) digestText(now time.Time) (string, error) {
+	title, from := digestDailyTxt, now.AddDate(0, 0, -1)
+	if h.digest.Every == config.DigestWeekly {
+		title, from = digestWeeklyTxt, now.AddDate(0, 0, -7)
+	}
+
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return "", errors.Wrap(err, "GetContacts")
+	}
+	history, err := h.storage.GetAllHistory()
+	if err != nil {
+		return "", errors.Wrap(err, "GetAllHistory")
+	}
+	pending, err := h.storage.GetPending()
+	if err != nil {
+		return "", errors.Wrap(err, "GetPending")
+	}
+	breaches, err := h.slaBreaches(now)
+	if err != nil {
+		return "", errors.Wrap(err, "slaBreaches")
+	}
+	broadcasts, err := h.storage.GetBroadcasts(from)
+	if err != nil {
+		return "", errors.Wrap(err, "GetBroadcasts")
+	}
+
+	body := formatSummary(stats.Summarize(contacts, history, from, now), h.hours.loc) + "\n"
+	if len(pending) > 0 {
+		body += fmt.Sprintf("без ответа: %v\n", len(pending))
+		sort.Slice(pending, func(i, j int) bool {
+			return pending[i].Since.Before(pending[j].Since)
+		})
+		for _, p := range pending {
+			//unknown since for rows written before it was stored
+			if p.Since.IsZero() {
+				continue
+			}
+			body += fmt.Sprintf("дольше всех ждёт %v: %v\n", p.Id, now.Sub(p.Since).Round(time.Minute))
+			break
+		}
+	}
+	body += breaches
+
+	if len(broadcasts) > 0 {
+		body += "\nрассылки\n"
+		for _, b := range broadcasts {
+			body += fmt.Sprintf(broadcastTxt, b.Time.In(h.hours.loc).Format(statLayout), b.Admin, b.Sent, b.Blocked, b.Failed)
+		}
+	}
+	head, tail := "<b>"+title+"</b>\n<pre>", "</pre>"
+	body = fitLines(body, messageLimit-utf8.RuneCountInString(head+tail))
+	return head + html.EscapeString(body) + tail, nil
+}
+
+//first lines of body that fit limit characters once escaped, the lines
+//left out are counted in the last one
+func fitLines(body string, limit int) string {
+	if utf8.RuneCountInString(html.EscapeString(body)) <= limit {
+		return body
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(body, "\n"), "\n")
+	out, size := "", 0
+	for i, line := range lines {
+		n := utf8.RuneCountInString(html.EscapeString(line))
+		more := utf8.RuneCountInString(fmt.Sprintf(digestMoreTxt, len(lines)-i-1))
+		if size+n+more > limit {
+			return out + fmt.Sprintf(digestMoreTxt, len(lines)-i)
+		}
+		out += line
+		size += n
+	}
+	return out
+}
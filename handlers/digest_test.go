@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitLines(t *testing.T) {
+	short := "a\nb\n"
+	if got := fitLines(short, 100); got != short {
+		t.Errorf("fitLines(short) = %q, want it unchanged", got)
+	}
+
+	body := ""
+	for i := 0; i < 1000; i++ {
+		body += fmt.Sprintf("рассылка %v <@admin>: доставлено %v\n", i, i)
+	}
+	got := fitLines(body, messageLimit)
+	if n := utf8.RuneCountInString(html.EscapeString(got)); n > messageLimit {
+		t.Fatalf("fitted body is %v characters, want at most %v", n, messageLimit)
+	}
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	kept := len(lines) - 1
+	want := fmt.Sprintf(digestMoreTxt, 1000-kept)
+	if last := lines[kept] + "\n"; last != want {
+		t.Errorf("last line = %q, want %q", last, want)
+	}
+	if !strings.HasPrefix(body, strings.Join(lines[:kept], "\n")) {
+		t.Error("kept lines are not the first lines of the body")
+	}
+}
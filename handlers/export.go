@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	exportHelp = `/export (id, @nick или тикет id-номер) [html|json|csv] [с ГГГГ-ММ-ДД] [по ГГГГ-ММ-ДД]
+в ответ на сообщение пользователя id можно не указывать`
+	ticketNotFound = "тикет не найден"
+)
+
+var ticketRe = regexp.MustCompile(`^(\d+)-(\d+)$`)
+
+var exportTmpl = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 800px; margin: auto; }
+.msg { margin: 8px 0; padding: 6px 10px; border-radius: 6px; white-space: pre-wrap; }
+.in { background: #eef; }
+.out { background: #efe; margin-left: 60px; }
+.broadcast { background: #eee; margin-left: 60px; }
+.meta { color: #777; font-size: 12px; }
+</style>
+</head>
+<body>
+<h2>{{.Title}}</h2>
+{{range .Messages}}<div class="msg {{.Direction}}">
+<div class="meta">{{.Time.Format "02.01.2006 15:04:05"}} {{if eq .Direction "in"}}пользователь{{else if eq .Direction "broadcast"}}рассылка @{{.Admin}}{{else}}@{{.Admin}}{{end}}{{if .FileId}} [{{.Type}}: {{.FileId}}]{{end}}</div>
+{{.Text}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+type exportRecord struct {
+	UserId    int64     `json:"user_id"`
+	MsgId     int       `json:"message_id"`
+	Direction string    `json:"direction"`
+	Admin     string    `json:"admin,omitempty"`
+	Type      string    `json:"type"`
+	FileId    string    `json:"file_id,omitempty"`
+	Text      string    `json:"text"`
+	Time      time.Time `json:"time"`
+}
+
+//transcript of a user or a ticket as a document
+func (h *handler) Export(id int64, args string, reply *tgbotapi.Message) error {
+	fields := strings.Fields(args)
+	target := ""
+	if len(fields) > 0 && !isExportOption(fields[0]) {
+		target, fields = fields[0], fields[1:]
+	}
+
+	format, from, to, ok := parseExportArgs(fields)
+	if !ok {
+		return h.send(id, exportHelp)
+	}
+
+	msgs, title, err := h.exportMessages(id, target, reply)
+	if err != nil {
+		return errors.Wrap(err, "exportMessages")
+	}
+	if msgs == nil {
+		if title == "" {
+			return h.userNotResolved(id, target, reply, exportHelp)
+		}
+		return h.send(id, title)
+	}
+	if !from.IsZero() || !to.IsZero() {
+		msgs = filterPeriod(msgs, from, to)
+	}
+	if len(msgs) == 0 {
+		return h.send(id, historyEmpty)
+	}
+
+	data, err := renderExport(msgs, title, format)
+	if err != nil {
+		return errors.Wrap(err, "renderExport")
+	}
+	name := strings.NewReplacer(" ", "_", "@", "").Replace(title) + "." + format
+	doc := tgbotapi.NewDocument(id, tgbotapi.FileBytes{Name: name, Bytes: data})
+	_, err = h.bot.Send(doc)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//format and period: "с" and "по" mark the dates, bare dates are the start
+//and the end in order. to is the day after the last one, zero when not set
+func parseExportArgs(fields []string) (string, time.Time, time.Time, bool) {
+	format := "html"
+	var from, to time.Time
+	bound := ""
+	for _, f := range fields {
+		switch f {
+		case "html", "json", "csv":
+			if bound != "" {
+				return "", time.Time{}, time.Time{}, false
+			}
+			format = f
+			continue
+		case "с", "по":
+			if bound != "" {
+				return "", time.Time{}, time.Time{}, false
+			}
+			bound = f
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", f, time.Local)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, false
+		}
+		if bound == "" {
+			bound = "с"
+			if !from.IsZero() {
+				bound = "по"
+			}
+		}
+		switch {
+		case bound == "с" && from.IsZero():
+			from = day
+		case bound == "по" && to.IsZero():
+			to = day.AddDate(0, 0, 1)
+		default:
+			return "", time.Time{}, time.Time{}, false
+		}
+		bound = ""
+	}
+	//a word without its date
+	if bound != "" {
+		return "", time.Time{}, time.Time{}, false
+	}
+	return format, from, to, true
+}
+
+func isExportOption(s string) bool {
+	switch s {
+	case "html", "json", "csv", "с", "по":
+		return true
+	}
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+//messages of a user or a ticket, nil when nothing matches
+func (h *handler) exportMessages(id int64, target string, reply *tgbotapi.Message) ([]database.Message, string, error) {
+	if m := ticketRe.FindStringSubmatch(target); m != nil {
+		userId, _ := strconv.ParseInt(m[1], 10, 64)
+		num, _ := strconv.Atoi(m[2])
+		msgs, err := h.storage.GetHistory(userId)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "GetHistory")
+		}
+		for _, t := range database.SplitTickets(msgs) {
+			if t.Num == num {
+				return t.Messages, "ticket " + t.Id(), nil
+			}
+		}
+		return nil, ticketNotFound, nil
+	}
+
+	userId, err := h.resolveUser(id, target, reply)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "resolveUser")
+	}
+	if userId == 0 {
+		return nil, "", nil
+	}
+	msgs, err := h.storage.GetHistory(userId)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "GetHistory")
+	}
+	if msgs == nil {
+		msgs = []database.Message{}
+	}
+	return msgs, fmt.Sprintf("history %v", userId), nil
+}
+
+//messages since from and before to, zero to means no upper bound
+func filterPeriod(msgs []database.Message, from, to time.Time) []database.Message {
+	out := make([]database.Message, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Time.Before(from) || (!to.IsZero() && !m.Time.Before(to)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func renderExport(msgs []database.Message, title, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "json":
+		records := make([]exportRecord, 0, len(msgs))
+		for _, m := range msgs {
+			records = append(records, exportRecord(m))
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(records)
+		if err != nil {
+			return nil, errors.Wrap(err, "Encode")
+		}
+	case "csv":
+		w := csv.NewWriter(&buf)
+		err := w.Write([]string{"time", "user_id", "message_id", "direction", "admin", "type", "file_id", "text"})
+		if err != nil {
+			return nil, errors.Wrap(err, "Write")
+		}
+		for _, m := range msgs {
+			err := w.Write([]string{
+				m.Time.Format(time.RFC3339),
+				strconv.FormatInt(m.UserId, 10),
+				strconv.Itoa(m.MsgId),
+				m.Direction,
+				m.Admin,
+				m.Type,
+				m.FileId,
+				m.Text,
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "Write")
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, errors.Wrap(err, "Flush")
+		}
+	default:
+		err := exportTmpl.Execute(&buf, struct {
+			Title    string
+			Messages []database.Message
+		}{title, msgs})
+		if err != nil {
+			return nil, errors.Wrap(err, "Execute")
+		}
+	}
+	return buf.Bytes(), nil
+}
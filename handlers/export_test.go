@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExportArgs(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		args   string
+		ok     bool
+		format string
+		from   string
+		to     string
+	}{
+		{"", true, "html", "", ""},
+		{"csv", true, "csv", "", ""},
+		{"json 2027-01-01", true, "json", "2027-01-01", ""},
+		{"2027-01-01 2027-01-31", true, "html", "2027-01-01", "2027-02-01"},
+		{"с 2027-01-01 по 2027-01-31", true, "html", "2027-01-01", "2027-02-01"},
+		{"по 2027-01-31 с 2027-01-01 csv", true, "csv", "2027-01-01", "2027-02-01"},
+		{"по 2027-01-31", true, "html", "", "2027-02-01"},
+		{"с", false, "", "", ""},
+		{"с по 2027-01-31", false, "", "", ""},
+		{"с csv 2027-01-01", false, "", "", ""},
+		{"с 2027-01-01 с 2027-01-02", false, "", "", ""},
+		{"2027-01-01 2027-01-02 2027-01-03", false, "", "", ""},
+		{"01.01.2027", false, "", "", ""},
+	}
+	for _, tt := range tests {
+		format, from, to, ok := parseExportArgs(strings.Fields(tt.args))
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v", tt.args, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if format != tt.format {
+			t.Errorf("%q: format = %v, want %v", tt.args, format, tt.format)
+		}
+		var wantFrom, wantTo time.Time
+		if tt.from != "" {
+			wantFrom = day(tt.from)
+		}
+		if tt.to != "" {
+			wantTo = day(tt.to)
+		}
+		if !from.Equal(wantFrom) || !to.Equal(wantTo) {
+			t.Errorf("%q: period %v - %v, want %v - %v", tt.args, from, to, wantFrom, wantTo)
+		}
+	}
+}
@@ -1,9 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
+	"github.com/CookieNyanCloud/tg-connection-base/config"
 	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/CookieNyanCloud/tg-connection-base/metrics"
+	"github.com/CookieNyanCloud/tg-connection-base/search"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
@@ -17,8 +26,14 @@ const (
 
 	banOk      = `Пользователь забанен`
 
+	banHelp    = "/setban (nickname или id) - id для пользователей без ника"
+
 	feedback    = `Спасибо! Ваше сообщение принято. Если хотите дополнить, пишите нам ещё.`
 
+	responseTimeTxt = "\nОбычно мы отвечаем в течение %v."
+
+	offHoursTxt = `Спасибо! Ваше сообщение принято. Сейчас нерабочее время, мы ответим после %v.`
+
 	regionStart = `Пожалуйста, введите регион вашего проживания:`
 
 	regionOk    = `регион успешно сохранён`
@@ -28,6 +43,14 @@ const (
 	bannedTxt   = "ваш аккаунт был заблокирован"
 
 	alreadyAnswered   = "на сообщение уже ответили"
+
+	queueEmpty = "очередь пуста"
+
+	nextTxt = "пользователь %v, сообщения:"
+
+	disabledTxt = "таблица %v не настроена: задайте SHEET_%v или SHEETS_ID"
+
+	allHelp = "/all [#тег] (текст) - отправить всем пользователям или только с тегами, текст обязателен"
 )
 
 type IStorage interface {
@@ -40,19 +63,51 @@ type IStorage interface {
 	// users
 	SaveContact(id int64, name, nick string) error
 	SaveRegion(id int64, region string) error
+	SetBlocked(id int64, blocked bool) error
 	GetAll() ([]int64, error)
 	SaveMsg(id int64, msgId int) error
 	GetStat() (map[string]int, error)
+	GetContact(id int64) (*database.Contact, error)
+	GetPending() ([]database.Pending, error)
+	ClearPending(id int64) error
+	GetContacts() ([]database.Contact, error)
+	// history
+	SaveHistory(msgs ...database.Message) error
+	GetHistory(userId int64) ([]database.Message, error)
+	GetAllHistory() ([]database.Message, error)
+	// results of /all for the digest
+	SaveBroadcast(b database.Broadcast) error
+	GetBroadcasts(since time.Time) ([]database.Broadcast, error)
+	// admin notes and tags
+	AddNote(n database.Note) error
+	GetNotes(userId int64) ([]database.Note, error)
+	AddTag(userId int64, tag string) error
+	RemoveTag(userId int64, tag string) error
+	GetTags() (map[int64][]string, error)
+	// canned replies
+	LoadCanned() (map[string]string, error)
+	SaveCanned(name, text string) error
+	DeleteCanned(name string) error
 }
 
 type ICache interface {
-	SetUser(msgId int, userId int64) error
-	GetUser(msgId int) (int64, error)
+	// message ids are unique only within a chat, 0 when there is no mapping
+	SetUser(chatId int64, msgId int, userId int64) error
+	GetUser(chatId int64, msgId int) (int64, error)
 	SetBan(userId int64) error
 	GetBan(userId int64) (bool, error)
 
-	SetAnswered(msgId int, admin string) error
-	GetAnswered(msgId int) (string, error)
+	SetAnswered(chatId int64, msgId int, admin string) error
+	GetAnswered(chatId int64, msgId int) (string, error)
+
+	// true only for the first call until ttl expires
+	SetOffHours(userId int64, ttl time.Duration) (bool, error)
+	SetAcked(userId int64, ttl time.Duration) (bool, error)
+
+	SetAssigned(userId int64, admin string) error
+	GetAssigned(userId int64) (string, error)
+	SetSLANotified(userId int64, since time.Time, level int) (bool, error)
+	ClearSLANotified(userId int64, since time.Time, level int) error
 }
 
 type handler struct {
@@ -60,13 +115,26 @@ type handler struct {
 	storage IStorage
 	bot     *tgbotapi.BotAPI
 
+	hours        *workHours
+	responseTime string
+	ackWindow    time.Duration
+	sla          config.SLAConfig
+	digest       config.DigestConfig
+
 	inRegionDialog map[int64]bool
 
+	//admins are read by background jobs, canned replies by callbacks
+	mu          sync.RWMutex
 	admins      map[string]database.Admin
 	bannedUsers map[string]struct{}
+	canned      map[string]string
+	//one /next at a time so that two admins do not take the same user
+	nextMu sync.Mutex
+
+	index *search.Index
 }
 
-func New(cache ICache, sheets IStorage, bot *tgbotapi.BotAPI) *handler {
+func New(cache ICache, sheets IStorage, bot *tgbotapi.BotAPI, conf *config.Conf) *handler {
 	admins, err := sheets.LoadAdmins()
 	if err != nil {
 		//log.Fatalf("loadAdmins: %v", err)
@@ -75,21 +143,40 @@ func New(cache ICache, sheets IStorage, bot *tgbotapi.BotAPI) *handler {
 
 	bannedUsers, _ := sheets.LoadBanned()
 
-	return &handler{
+	canned, err := sheets.LoadCanned()
+	if err != nil {
+		fmt.Printf("LoadCanned: %v\n", err)
+		canned = make(map[string]string)
+	}
+
+	h := &handler{
 		cache:          cache,
 		storage:        sheets,
 		bot:            bot,
+		hours:          newWorkHours(conf.Work),
+		responseTime:   conf.Work.ResponseTime,
+		ackWindow:      conf.Feedback.AckWindow,
+		sla:            conf.SLA,
+		digest:         conf.Digest,
 		inRegionDialog: make(map[int64]bool),
 		admins:         admins,
 		bannedUsers:    bannedUsers,
+		canned:         canned,
+		index:          search.New(),
 	}
+	go func() {
+		if err := h.buildIndex(); err != nil {
+			fmt.Printf("buildIndex: %v\n", err)
+		}
+	}()
+	return h
 }
 
 type IHandler interface {
 	Unknown(id int64) error
 	//user
 	Starting(id int64, name, nick string) error
-	Feedback(id int64, msgId int) error
+	Feedback(m *tgbotapi.Message) error
 	StartRegionDialog(id int64) error
 	InRegionDialog(id int64) bool
 	EndRegionDialog(id int64, region string) error
@@ -99,11 +186,23 @@ type IHandler interface {
 	//admin
 	AddAdmin(id int64, nick string) error
 	SetBan(id int64, nick string) error
-	ReplyToMsg(msgId int, txt string, chat_id int64, admin string) error
-	SendAll(txt string) error
-	Find(toId int64) error
+	ReplyToMsg(reply *tgbotapi.Message, txt string, chat_id int64, admin string) error
+	SendAll(id int64, txt, admin string) error
+	Find(toId int64, admin string) error
+	Queue(id int64) error
 	IsAdmin(nick string) bool
-	Stat(id int64) error
+	Stat(id int64, args string) error
+	Canned(id int64, args string, reply *tgbotapi.Message) error
+	ReplyCanned(reply *tgbotapi.Message, name string, chatId int64, admin string) error
+	Callback(q *tgbotapi.CallbackQuery) error
+	History(id int64, args string, reply *tgbotapi.Message) error
+	Export(id int64, args string, reply *tgbotapi.Message) error
+	Search(id int64, query string) error
+	Profile(id int64, args string, reply *tgbotapi.Message) error
+	Note(id int64, args string, reply *tgbotapi.Message, admin string) error
+	Tag(id int64, args string, reply *tgbotapi.Message) error
+	RunSLA(ctx context.Context)
+	RunDigest(ctx context.Context)
 }
 
 //unknown command
@@ -123,28 +222,56 @@ func (h *handler) Starting(id int64, name, nick string) error {
 	if err != nil {
 		return errors.Wrap(err, "Send")
 	}
+	//a user who blocked the bot has to unblock it to press start again
+	contact, err := h.storage.GetContact(id)
+	if err != nil {
+		return errors.Wrap(err, "GetContact")
+	}
+	if contact != nil {
+		if !contact.Blocked.IsZero() {
+			err = h.storage.SetBlocked(id, false)
+			if err != nil {
+				return errors.Wrap(err, "SetBlocked")
+			}
+		}
+		return nil
+	}
 	err = h.storage.SaveContact(id, name, nick)
 	if err != nil {
 		return errors.Wrap(err, "SaveContact")
 	}
+	h.indexContact(database.Contact{Id: id, Name: name, Nick: nick})
 	return nil
 }
 
 //save message id, answered when needed
-func (h *handler) Feedback(id int64, msgId int) error {
+func (h *handler) Feedback(m *tgbotapi.Message) error {
+	id, msgId := m.Chat.ID, m.MessageID
+	//admins get the message even while storage or the ack is down,
+	//the error is returned after forwarding
+	var firstErr error
 	err := h.storage.SaveMsg(id, msgId)
 	if err != nil {
-		return errors.Wrap(err, "SaveMsg")
+		firstErr = errors.Wrap(err, "SaveMsg")
+	}
+	err = h.storage.SaveHistory(historyMessage(m, id, database.DirIn, ""))
+	if err != nil && firstErr == nil {
+		firstErr = errors.Wrap(err, "SaveHistory")
 	}
+	h.index.Add(id, m.Text+" "+m.Caption)
 
-	msg := tgbotapi.NewMessage(id, feedback)
-	_, err = h.bot.Send(msg)
-	if err != nil {
-		return errors.Wrap(err, "Send")
+	//a failed acknowledgement does not stop forwarding either
+	text := h.ackText(id, time.Now())
+	if text != "" {
+		msg := tgbotapi.NewMessage(id, text)
+		_, err = h.bot.Send(msg)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "Send")
+		}
 	}
 
-	//send to all admins
-	for _, admin := range h.admins {
+	//send to all admins, one failed admin does not stop the others
+	for _, admin := range h.adminList() {
 		if admin.ChatId == 0 {
 			continue
 		}
@@ -159,16 +286,66 @@ func (h *handler) Feedback(id int64, msgId int) error {
 
 		forwarded, err := h.bot.Send(msg)
 		if err != nil {
-			return errors.Wrap(err, "Send")
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "Send %v", admin.ChatId)
+			}
+			continue
 		}
 
-		err = h.cache.SetUser(forwarded.MessageID, id)
+		err = h.cache.SetUser(admin.ChatId, forwarded.MessageID, id)
 		if err != nil {
-			return errors.Wrap(err, "SetUser")
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "SetUser %v", admin.ChatId)
+			}
+			continue
+		}
+
+		//sender card once per burst, like the acknowledgement
+		if text != "" {
+			err = h.sendSenderCard(admin.ChatId, forwarded.MessageID, m)
+			if err != nil && firstErr == nil {
+				firstErr = errors.Wrapf(err, "sendSenderCard %v", admin.ChatId)
+			}
 		}
 	}
 
-	return nil
+	return firstErr
+}
+
+//acknowledgement, off-hours notice once per closed period,
+//empty when the user was already acknowledged within ackWindow.
+//cache errors are only logged and the user gets the acknowledgement
+func (h *handler) ackText(id int64, now time.Time) string {
+	if !h.hours.IsOpen(now) {
+		next := h.hours.NextOpen(now)
+		//a zero ttl would never expire
+		ttl := next.Sub(now)
+		if ttl < time.Minute {
+			ttl = time.Minute
+		}
+		first, err := h.cache.SetOffHours(id, ttl)
+		if err != nil {
+			fmt.Printf("SetOffHours %v: %v\n", id, err)
+			first = true
+		}
+		if first {
+			return fmt.Sprintf(offHoursTxt, next.Format("15:04 02.01"))
+		}
+	}
+	if h.ackWindow > 0 {
+		first, err := h.cache.SetAcked(id, h.ackWindow)
+		if err != nil {
+			fmt.Printf("SetAcked %v: %v\n", id, err)
+			first = true
+		}
+		if !first {
+			return ""
+		}
+	}
+	if h.responseTime != "" {
+		return feedback + fmt.Sprintf(responseTimeTxt, h.responseTime)
+	}
+	return feedback
 }
 
 func (h *handler) StartRegionDialog(id int64) error {
@@ -188,7 +365,7 @@ func (h *handler) InRegionDialog(id int64) bool {
 }
 
 func (h *handler) IsBanned(id int64, name string) (bool, error) {
-	_, banned := h.bannedUsers[name]
+	banned := h.isBanned(id, name)
 	if banned {
 		msg := tgbotapi.NewMessage(id, bannedTxt)
 		h.bot.Send(msg)
@@ -197,11 +374,23 @@ func (h *handler) IsBanned(id int64, name string) (bool, error) {
 	return banned, nil
 }
 
+//banned by nick or, for users without one, by id
+func (h *handler) isBanned(id int64, nick string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.bannedUsers[strconv.FormatInt(id, 10)]; ok {
+		return true
+	}
+	_, ok := h.bannedUsers[nick]
+	return ok && nick != ""
+}
+
 func (h *handler) EndRegionDialog(id int64, region string) error {
 	err := h.storage.SaveRegion(id, region)
 	if err != nil {
 		return errors.Wrap(err, "SaveRegion")
 	}
+	h.index.Add(id, region)
 
 	delete(h.inRegionDialog, id)
 
@@ -221,7 +410,9 @@ func (h *handler) AddAdmin(id int64, nick string) error {
 		return errors.Wrap(err, "SaveAdmin")
 	}
 
-	h.admins[nick] = database.Admin{nick, 0}
+	h.mu.Lock()
+	h.admins[nick] = database.Admin{Nick: nick}
+	h.mu.Unlock()
 
 	msg := tgbotapi.NewMessage(id, adminOk)
 	_, err = h.bot.Send(msg)
@@ -233,12 +424,18 @@ func (h *handler) AddAdmin(id int64, nick string) error {
 }
 
 func (h *handler) SetBan(id int64, nick string) error {
+	//an empty nick would ban everyone without one
+	if nick == "" {
+		return h.send(id, banHelp)
+	}
 	err := h.storage.SetBan(nick)
 	if err != nil {
 		return errors.Wrap(err, "SetBan")
 	}
 
+	h.mu.Lock()
 	h.bannedUsers[nick] = struct{}{}
+	h.mu.Unlock()
 
 	msg := tgbotapi.NewMessage(id, banOk)
 	_, err = h.bot.Send(msg)
@@ -250,16 +447,31 @@ func (h *handler) SetBan(id int64, nick string) error {
 }
 
 func (h *handler) IsAdmin(nick string) bool {
-		_, ok := h.admins[nick]
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.admins[nick]
 	return ok
 }
 
-//get last user to answer
-func (h *handler) Find(toId int64) error {
+//get last user to answer and assign him to the admin
+func (h *handler) Find(toId int64, admin string) error {
+	h.nextMu.Lock()
+	defer h.nextMu.Unlock()
 	fromId, msgIds, err := h.storage.GetLast()
+	if err == database.ErrNoRows {
+		return h.send(toId, queueEmpty)
+	}
 	if err != nil {
 		return errors.Wrap(err, "GetLast")
 	}
+	err = h.cache.SetAssigned(fromId, admin)
+	if err != nil {
+		return errors.Wrap(err, "SetAssigned")
+	}
+	err = h.send(toId, fmt.Sprintf(nextTxt, fromId))
+	if err != nil {
+		return errors.Wrap(err, "send")
+	}
 	for _, id := range msgIds {
 		msg := tgbotapi.ForwardConfig{
 			BaseChat: tgbotapi.BaseChat{
@@ -272,19 +484,25 @@ func (h *handler) Find(toId int64) error {
 		if err != nil {
 			return errors.Wrap(err, "Send")
 		}
-		err = h.cache.SetUser(forwarded.MessageID, fromId)
+		err = h.cache.SetUser(toId, forwarded.MessageID, fromId)
 
 		if err != nil {
 			return errors.Wrap(err, "SetUser")
 		}
 
 	}
+	//the user leaves the queue only when the admin got every message
+	err = h.storage.ClearPending(fromId)
+	if err != nil {
+		return errors.Wrap(err, "ClearPending")
+	}
 	return nil
 }
 
 //answer to message
-func (h *handler) ReplyToMsg(msgId int, txt string, chat_id int64, admin string) error {
-	other_admin, err := h.cache.GetAnswered(msgId)
+func (h *handler) ReplyToMsg(reply *tgbotapi.Message, txt string, chat_id int64, admin string) error {
+	msgId := reply.MessageID
+	other_admin, err := h.cache.GetAnswered(chat_id, msgId)
 	if err != nil {
 		return errors.Wrap(err, "GetAnswered")
 	}
@@ -298,20 +516,45 @@ func (h *handler) ReplyToMsg(msgId int, txt string, chat_id int64, admin string)
 		return nil
 	}
 
-	userId, err := h.cache.GetUser(msgId)
+	userId, err := h.replyUser(chat_id, reply)
 	if err != nil {
-		return errors.Wrap(err, "GetUser")
+		return errors.Wrap(err, "replyUser")
+	}
+	if userId == 0 {
+		return h.send(chat_id, replyUnknown)
 	}
 	msg := tgbotapi.NewMessage(userId, txt)
 	answer, send_err := h.bot.Send(msg)
 	if send_err != nil {
+		if isBlocked(send_err) {
+			h.markBlocked(userId)
+		}
 		return errors.Wrap(send_err, "Send")
 	}
 
-	err = h.cache.SetAnswered(msgId, admin)
+	//the answer is already sent, the rest is finished even if a step fails
+	//and the first error is returned at the end
+	var firstErr error
+	err = h.storage.SaveHistory(historyMessage(&answer, userId, database.DirOut, admin))
+	if err != nil {
+		firstErr = errors.Wrap(err, "SaveHistory")
+	}
+
+	err = h.cache.SetAnswered(chat_id, msgId, admin)
+	if err != nil && firstErr == nil {
+		firstErr = errors.Wrap(err, "SetAnswered")
+	}
+	err = h.cache.SetAssigned(userId, admin)
+	if err != nil {
+		fmt.Printf("SetAssigned %v: %v\n", userId, err)
+	}
+	err = h.storage.ClearPending(userId)
+	if err != nil {
+		fmt.Printf("ClearPending %v: %v\n", userId, err)
+	}
 
 	//send answer to all admins
-	for _, other_admin := range h.admins {
+	for _, other_admin := range h.adminList() {
 		if other_admin.ChatId == 0 || other_admin.Nick == admin {
 			continue
 		}
@@ -325,45 +568,115 @@ func (h *handler) ReplyToMsg(msgId int, txt string, chat_id int64, admin string)
 		}
 
 		_, err := h.bot.Send(msg)
-		if err != nil {
-			return errors.Wrap(err, "Send")
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "Send %v", other_admin.Nick)
 		}
 	}
 
-	return nil
+	return firstErr
 }
 
-//send text to everyone
-func (h *handler) SendAll(txt string) error {
-	all, err := h.storage.GetAll()
+//send text to everyone or to users with the leading #tags, users who
+//blocked the bot are skipped
+func (h *handler) SendAll(id int64, txt, admin string) error {
+	tags, txt, ok := splitTagFilter(txt)
+	if !ok || txt == "" {
+		return h.send(id, allHelp)
+	}
+	contacts, err := h.storage.GetContacts()
 	if err != nil {
-		return errors.Wrap(err, "GetAll")
+		return errors.Wrap(err, "GetContacts")
+	}
+	var tagged map[int64]bool
+	if len(tags) > 0 {
+		tagged, err = h.usersWithTags(tags)
+		if err != nil {
+			return errors.Wrap(err, "usersWithTags")
+		}
+	}
+	sent := make([]database.Message, 0, len(contacts))
+	result := database.Broadcast{Time: time.Now(), Admin: admin}
+	to := make([]int64, 0, len(contacts))
+	for _, c := range contacts {
+		if c.Blocked.IsZero() && (tagged == nil || tagged[c.Id]) {
+			to = append(to, c.Id)
+		}
 	}
-	for _, id := range all {
+	metrics.BroadcastTotal.Set(float64(len(to)))
+	metrics.BroadcastDone.Set(0)
+	var sendErr error
+	for _, id := range to {
 		msg := tgbotapi.NewMessage(id, txt)
-		_, err := h.bot.Send(msg)
+		answer, err := h.bot.Send(msg)
+		metrics.BroadcastDone.Inc()
 		if err != nil {
-			return errors.Wrap(err, "Send")
+			if isBlocked(err) {
+				result.Blocked++
+				metrics.BroadcastMessages.WithLabelValues("blocked").Inc()
+				h.markBlocked(id)
+				continue
+			}
+			//the rest still get the message
+			result.Failed++
+			metrics.BroadcastMessages.WithLabelValues("failed").Inc()
+			if sendErr == nil {
+				sendErr = errors.Wrapf(err, "Send %v", id)
+			}
+			continue
 		}
+		result.Sent++
+		metrics.BroadcastMessages.WithLabelValues("sent").Inc()
+		sent = append(sent, historyMessage(&answer, id, database.DirBroadcast, admin))
 	}
-	return nil
-}
-
-func (h *handler) Stat(id int64) error {
-	stat, err := h.storage.GetStat()
+	err = h.storage.SaveHistory(sent...)
+	if err != nil {
+		return errors.Wrap(err, "SaveHistory")
+	}
+	err = h.storage.SaveBroadcast(result)
 	if err != nil {
-		return errors.Wrap(err, "GetStat")
+		return errors.Wrap(err, "SaveBroadcast")
 	}
+	return sendErr
+}
 
-	stat_text := "статистика по боту\n"
-	for key, value := range stat {
-		stat_text += fmt.Sprintf("%v = %v\n", key, value)
+func (h *handler) adminList() []database.Admin {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]database.Admin, 0, len(h.admins))
+	for _, admin := range h.admins {
+		out = append(out, admin)
 	}
+	return out
+}
 
-	msg := tgbotapi.NewMessage(id, stat_text)
-	_, err = h.bot.Send(msg)
+func (h *handler) send(id int64, text string) error {
+	msg := tgbotapi.NewMessage(id, text)
+	_, err := h.bot.Send(msg)
 	if err != nil {
 		return errors.Wrap(err, "Send")
 	}
 	return nil
 }
+
+//the optional table of a command has no spreadsheet
+func (h *handler) sendDisabled(id int64, table string) error {
+	return h.send(id, fmt.Sprintf(disabledTxt, table, strings.ToUpper(table)))
+}
+
+//split command arguments into at most n parts, the last one keeps the rest of the text
+func splitArgs(args string, n int) []string {
+	out := make([]string, 0, n)
+	rest := strings.TrimSpace(args)
+	for rest != "" && len(out) < n-1 {
+		i := strings.IndexFunc(rest, unicode.IsSpace)
+		if i < 0 {
+			break
+		}
+		out = append(out, rest[:i])
+		rest = strings.TrimSpace(rest[i:])
+	}
+	if rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	historyPage     = 10
+	historyTextMax  = 300
+	historyCallback = "history:"
+
+	historyEmpty  = "сообщений нет"
+	userNotFound  = "пользователь не найден"
+	historyHelp   = "/history (id или @nick) - в ответ на сообщение пользователя можно без аргументов"
+	historyHeader = "история %v, стр. %v/%v\n\n"
+	senderTxt     = "👤 %v"
+	historyButton = "История"
+	historyOlder  = "◀ раньше"
+	historyNewer  = "позже ▶"
+)
+
+//message from telegram to history record
+func historyMessage(m *tgbotapi.Message, userId int64, direction, admin string) database.Message {
+	out := database.Message{
+		UserId:    userId,
+		MsgId:     m.MessageID,
+		Direction: direction,
+		Admin:     admin,
+		Type:      "text",
+		Text:      m.Text,
+		Time:      m.Time(),
+	}
+	if m.Caption != "" {
+		out.Text = m.Caption
+	}
+	switch {
+	case len(m.Photo) > 0:
+		out.Type, out.FileId = "photo", m.Photo[len(m.Photo)-1].FileID
+	case m.Document != nil:
+		out.Type, out.FileId = "document", m.Document.FileID
+	case m.Voice != nil:
+		out.Type, out.FileId = "voice", m.Voice.FileID
+	case m.Audio != nil:
+		out.Type, out.FileId = "audio", m.Audio.FileID
+	case m.Video != nil:
+		out.Type, out.FileId = "video", m.Video.FileID
+	case m.VideoNote != nil:
+		out.Type, out.FileId = "video_note", m.VideoNote.FileID
+	case m.Animation != nil:
+		out.Type, out.FileId = "animation", m.Animation.FileID
+	case m.Sticker != nil:
+		out.Type, out.FileId = "sticker", m.Sticker.FileID
+	case m.Location != nil:
+		out.Type = "location"
+		out.Text = fmt.Sprintf("%v,%v", m.Location.Latitude, m.Location.Longitude)
+	case m.Contact != nil:
+		out.Type = "contact"
+		out.Text = m.Contact.PhoneNumber
+	}
+	return out
+}
+
+//user by id, @nick or the forwarded message the command replies to
+func (h *handler) resolveUser(chatId int64, arg string, reply *tgbotapi.Message) (int64, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return h.replyUser(chatId, reply)
+	}
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return id, nil
+	}
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return 0, errors.Wrap(err, "GetContacts")
+	}
+	nick := strings.TrimPrefix(arg, "@")
+	for _, c := range contacts {
+		if strings.EqualFold(c.Nick, nick) {
+			return c.Id, nil
+		}
+	}
+	return 0, nil
+}
+
+func (h *handler) History(id int64, args string, reply *tgbotapi.Message) error {
+	userId, err := h.resolveUser(id, args, reply)
+	if err != nil {
+		return errors.Wrap(err, "resolveUser")
+	}
+	if userId == 0 {
+		return h.userNotResolved(id, args, reply, historyHelp)
+	}
+	text, markup, err := h.historyPage(userId, 0)
+	if err != nil {
+		return errors.Wrap(err, "historyPage")
+	}
+	msg := tgbotapi.NewMessage(id, text)
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	_, err = h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//page 0 holds the newest messages
+func (h *handler) historyPage(userId int64, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	msgs, err := h.storage.GetHistory(userId)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "GetHistory")
+	}
+	if len(msgs) == 0 {
+		return historyEmpty, nil, nil
+	}
+	pages := (len(msgs) + historyPage - 1) / historyPage
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	end := len(msgs) - page*historyPage
+	start := end - historyPage
+	if start < 0 {
+		start = 0
+	}
+
+	text := fmt.Sprintf(historyHeader, userId, page+1, pages)
+	for _, m := range msgs[start:end] {
+		text += formatHistory(m) + "\n"
+	}
+
+	buttons := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+	if page+1 < pages {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(historyOlder,
+			fmt.Sprintf("%v%v:%v", historyCallback, userId, page+1)))
+	}
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(historyNewer,
+			fmt.Sprintf("%v%v:%v", historyCallback, userId, page-1)))
+	}
+	if len(buttons) == 0 {
+		return text, nil, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons)
+	return text, &markup, nil
+}
+
+func formatHistory(m database.Message) string {
+	from := "пользователь"
+	switch m.Direction {
+	case database.DirOut:
+		from = "@" + m.Admin
+	case database.DirBroadcast:
+		from = "рассылка @" + m.Admin
+	}
+	text := m.Text
+	if utf8.RuneCountInString(text) > historyTextMax {
+		text = string([]rune(text)[:historyTextMax]) + "…"
+	}
+	if m.Type != "" && m.Type != "text" {
+		text = fmt.Sprintf("[%v] %v", m.Type, text)
+	}
+	return fmt.Sprintf("%v %v: %v", m.Time.In(time.Local).Format("02.01 15:04"), from, text)
+}
+
+//history button pressed, data is history:<user> or history:<user>:<page>
+func (h *handler) historyCallback(q *tgbotapi.CallbackQuery) error {
+	parts := strings.Split(strings.TrimPrefix(q.Data, historyCallback), ":")
+	userId, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "ParseInt")
+	}
+	page := 0
+	if len(parts) > 1 {
+		page, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return errors.Wrap(err, "Atoi")
+		}
+	}
+	text, markup, err := h.historyPage(userId, page)
+	if err != nil {
+		return errors.Wrap(err, "historyPage")
+	}
+
+	//page buttons edit the transcript, the button under a forwarded message opens a new one
+	if len(parts) > 1 {
+		edit := tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, text)
+		edit.ReplyMarkup = markup
+		_, err = h.bot.Send(edit)
+		if err != nil {
+			return errors.Wrap(err, "Send")
+		}
+		return nil
+	}
+	msg := tgbotapi.NewMessage(q.Message.Chat.ID, text)
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	_, err = h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//sender card under a forwarded message with profile and history buttons
+func (h *handler) sendSenderCard(adminChat int64, forwardedId int, m *tgbotapi.Message) error {
+	name := strings.TrimSpace(m.From.FirstName + " " + m.From.LastName)
+	if m.From.UserName != "" {
+		name += " @" + m.From.UserName
+	}
+	card := tgbotapi.NewMessage(adminChat, fmt.Sprintf(senderTxt, name))
+	card.ReplyToMessageID = forwardedId
+	card.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(profileButton, fmt.Sprintf("%v%v", profileCallback, m.Chat.ID)),
+		tgbotapi.NewInlineKeyboardButtonData(historyButton, fmt.Sprintf("%v%v", historyCallback, m.Chat.ID)),
+	))
+	sent, err := h.bot.Send(card)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	//replies to the card go to the user as well
+	err = h.cache.SetUser(adminChat, sent.MessageID, m.Chat.ID)
+	if err != nil {
+		return errors.Wrap(err, "SetUser")
+	}
+	return nil
+}
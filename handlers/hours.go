@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+)
+
+type workHours struct {
+	loc      *time.Location
+	open     time.Duration
+	close    time.Duration
+	days     map[time.Weekday]bool
+	holidays map[string]bool
+}
+
+func newWorkHours(conf config.WorkConfig) *workHours {
+	w := &workHours{
+		loc:      conf.Location,
+		open:     conf.Open,
+		close:    conf.Close,
+		days:     make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+	}
+	if w.loc == nil {
+		w.loc = time.Local
+	}
+	for _, d := range conf.Days {
+		w.days[d] = true
+	}
+	for _, d := range conf.Holidays {
+		w.holidays[d] = true
+	}
+	return w
+}
+
+//no working days configured means the bot is always open
+func (w *workHours) enabled() bool {
+	return len(w.days) > 0
+}
+
+func (w *workHours) IsOpen(t time.Time) bool {
+	if !w.enabled() {
+		return true
+	}
+	t = t.In(w.loc)
+	since := t.Sub(midnight(t))
+	if w.open < w.close {
+		return w.workday(t) && since >= w.open && since < w.close
+	}
+	//overnight, 22:00-06:00 belongs to the day it starts on
+	yesterday := midnight(t).AddDate(0, 0, -1)
+	return w.workday(t) && since >= w.open || w.workday(yesterday) && since < w.close
+}
+
+//start of the next working period, t itself when open
+func (w *workHours) NextOpen(t time.Time) time.Time {
+	if w.IsOpen(t) {
+		return t
+	}
+	t = t.In(w.loc)
+	day := midnight(t)
+	for i := 0; i < 366; i++ {
+		if w.workday(day) {
+			open := day.Add(w.open)
+			if open.After(t) {
+				return open
+			}
+		}
+		day = midnight(day.AddDate(0, 0, 1))
+	}
+	return t
+}
+
+func (w *workHours) workday(t time.Time) bool {
+	return w.days[t.Weekday()] && !w.holidays[t.Format("2006-01-02")]
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+)
+
+func TestWorkHours(t *testing.T) {
+	loc := time.UTC
+	at := func(day, hour, min int) time.Time {
+		//2027-03-01 is a monday
+		return time.Date(2027, 3, day, hour, min, 0, 0, loc)
+	}
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	day := config.WorkConfig{Location: loc, Open: 10 * time.Hour, Close: 19 * time.Hour, Days: weekdays, Holidays: []string{"2027-03-03"}}
+	night := config.WorkConfig{Location: loc, Open: 22 * time.Hour, Close: 6 * time.Hour, Days: weekdays}
+
+	tests := []struct {
+		name string
+		conf config.WorkConfig
+		now  time.Time
+		open bool
+		next time.Time
+	}{
+		{"always open", config.WorkConfig{Location: loc}, at(6, 3, 0), true, at(6, 3, 0)},
+		{"before open", day, at(1, 9, 59), false, at(1, 10, 0)},
+		{"at open", day, at(1, 10, 0), true, at(1, 10, 0)},
+		{"at close", day, at(1, 19, 0), false, at(2, 10, 0)},
+		{"holiday", day, at(2, 20, 0), false, at(4, 10, 0)},
+		{"friday evening", day, at(5, 19, 30), false, at(8, 10, 0)},
+		{"weekend", day, at(6, 12, 0), false, at(8, 10, 0)},
+		{"night start", night, at(1, 22, 0), true, at(1, 22, 0)},
+		{"night after midnight", night, at(2, 5, 59), true, at(2, 5, 59)},
+		{"night close", night, at(2, 6, 0), false, at(2, 22, 0)},
+		{"monday morning", night, at(1, 3, 0), false, at(1, 22, 0)},
+		{"saturday morning", night, at(6, 3, 0), true, at(6, 3, 0)},
+		{"saturday evening", night, at(6, 22, 0), false, at(8, 22, 0)},
+	}
+	for _, tt := range tests {
+		w := newWorkHours(tt.conf)
+		if open := w.IsOpen(tt.now); open != tt.open {
+			t.Errorf("%v: IsOpen = %v, want %v", tt.name, open, tt.open)
+		}
+		if next := w.NextOpen(tt.now); !next.Equal(tt.next) {
+			t.Errorf("%v: NextOpen = %v, want %v", tt.name, next, tt.next)
+		}
+	}
+}
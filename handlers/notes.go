@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	noteHelp = `/note (текст) - в ответ на сообщение пользователя, или /note (id или @nick) (текст)
+заметки видят только админы`
+	tagHelp = `/tag (теги) - в ответ на сообщение пользователя, или /tag (id или @nick) (теги)
+тег или +тег - добавить, -тег - убрать, без тегов - показать`
+	noteOk    = "заметка сохранена"
+	tagsTxt   = "теги: %v"
+	tagsEmpty = "тегов нет"
+
+	profileNotes = 5
+)
+
+//user from the replied message or from the first argument, the rest of args is returned
+func (h *handler) targetUser(chatId int64, args string, reply *tgbotapi.Message) (int64, string, error) {
+	if reply != nil {
+		userId, err := h.replyUser(chatId, reply)
+		return userId, strings.TrimSpace(args), err
+	}
+	fields := splitArgs(args, 2)
+	if len(fields) == 0 {
+		return 0, "", nil
+	}
+	userId, err := h.resolveUser(chatId, fields[0], nil)
+	if err != nil || len(fields) < 2 {
+		return userId, "", err
+	}
+	return userId, fields[1], nil
+}
+
+func (h *handler) Note(id int64, args string, reply *tgbotapi.Message, admin string) error {
+	userId, text, err := h.targetUser(id, args, reply)
+	if err != nil {
+		return errors.Wrap(err, "targetUser")
+	}
+	if userId == 0 {
+		return h.userNotResolved(id, args, reply, noteHelp)
+	}
+	if text == "" {
+		return h.send(id, noteHelp)
+	}
+	err = h.storage.AddNote(database.Note{
+		UserId: userId,
+		Admin:  admin,
+		Text:   text,
+		Time:   time.Now(),
+	})
+	if err == database.ErrDisabled {
+		return h.sendDisabled(id, database.TableNotes)
+	}
+	if err != nil {
+		return errors.Wrap(err, "AddNote")
+	}
+	return h.send(id, noteOk)
+}
+
+func (h *handler) Tag(id int64, args string, reply *tgbotapi.Message) error {
+	userId, rest, err := h.targetUser(id, args, reply)
+	if err != nil {
+		return errors.Wrap(err, "targetUser")
+	}
+	if userId == 0 {
+		return h.userNotResolved(id, args, reply, tagHelp)
+	}
+	for _, t := range strings.Fields(rest) {
+		tag := normalizeTag(t)
+		if tag == "" {
+			continue
+		}
+		if strings.HasPrefix(t, "-") {
+			err = h.storage.RemoveTag(userId, tag)
+		} else {
+			err = h.storage.AddTag(userId, tag)
+		}
+		if err == database.ErrDisabled {
+			return h.sendDisabled(id, database.TableTags)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "tag %v", tag)
+		}
+	}
+	tags, err := h.userTags(userId)
+	if err != nil {
+		return errors.Wrap(err, "userTags")
+	}
+	if len(tags) == 0 {
+		return h.send(id, tagsEmpty)
+	}
+	return h.send(id, fmt.Sprintf(tagsTxt, formatTags(tags)))
+}
+
+func (h *handler) userTags(userId int64) ([]string, error) {
+	all, err := h.storage.GetTags()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetTags")
+	}
+	tags := all[userId]
+	sort.Strings(tags)
+	return tags, nil
+}
+
+//notes and tags for the profile card
+func (h *handler) profileNotes(userId int64) (string, error) {
+	tags, err := h.userTags(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "userTags")
+	}
+	notes, err := h.storage.GetNotes(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "GetNotes")
+	}
+	out := ""
+	if len(tags) > 0 {
+		out += fmt.Sprintf(tagsTxt+"\n", formatTags(tags))
+	}
+	if len(notes) > profileNotes {
+		notes = notes[len(notes)-profileNotes:]
+	}
+	if len(notes) > 0 {
+		out += "заметки:\n"
+	}
+	for _, n := range notes {
+		out += fmt.Sprintf("  %v @%v: %v\n", n.Time.Format("02.01.2006"), n.Admin, n.Text)
+	}
+	return out, nil
+}
+
+//leading #tags of command arguments and the remaining text, false if one
+//of the tags is empty like a lone #
+func splitTagFilter(args string) ([]string, string, bool) {
+	tags := make([]string, 0)
+	rest := strings.TrimSpace(args)
+	for strings.HasPrefix(rest, "#") {
+		fields := splitArgs(rest, 2)
+		tag := normalizeTag(fields[0])
+		if tag == "" {
+			return nil, "", false
+		}
+		tags = append(tags, tag)
+		rest = ""
+		if len(fields) > 1 {
+			rest = fields[1]
+		}
+	}
+	return tags, rest, true
+}
+
+//users having every one of the tags
+func (h *handler) usersWithTags(tags []string) (map[int64]bool, error) {
+	all, err := h.storage.GetTags()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetTags")
+	}
+	out := make(map[int64]bool)
+	for userId, userTags := range all {
+		has := make(map[string]bool, len(userTags))
+		for _, t := range userTags {
+			has[t] = true
+		}
+		ok := true
+		for _, t := range tags {
+			ok = ok && has[t]
+		}
+		if ok {
+			out[userId] = true
+		}
+	}
+	return out, nil
+}
+
+func normalizeTag(s string) string {
+	return strings.ToLower(strings.Trim(s, "#+-"))
+}
+
+func formatTags(tags []string) string {
+	return "#" + strings.Join(tags, " #")
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	profileCallback = "profile:"
+	profileButton   = "Профиль"
+	profileHelp     = "/user (id или @nick) - в ответ на сообщение пользователя можно без аргументов"
+	profileTickets  = 3
+)
+
+func (h *handler) Profile(id int64, args string, reply *tgbotapi.Message) error {
+	userId, err := h.resolveUser(id, args, reply)
+	if err != nil {
+		return errors.Wrap(err, "resolveUser")
+	}
+	if userId == 0 {
+		return h.userNotResolved(id, args, reply, profileHelp)
+	}
+	return h.sendProfile(id, userId)
+}
+
+func (h *handler) sendProfile(id, userId int64) error {
+	text, err := h.profileCard(userId)
+	if err != nil {
+		return errors.Wrap(err, "profileCard")
+	}
+	msg := tgbotapi.NewMessage(id, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(historyButton, fmt.Sprintf("%v%v", historyCallback, userId)),
+	))
+	_, err = h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//everything storage knows about a user
+func (h *handler) profileCard(userId int64) (string, error) {
+	contact, err := h.storage.GetContact(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "GetContact")
+	}
+	msgs, err := h.storage.GetHistory(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "GetHistory")
+	}
+	if contact == nil {
+		contact = &database.Contact{Id: userId}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "👤 %v\n", contactTitle(*contact))
+	fmt.Fprintf(&b, "chat id: %v\n", userId)
+	if contact.Name != "" {
+		fmt.Fprintf(&b, "имя: %v\n", contact.Name)
+	}
+	if contact.Nick != "" {
+		fmt.Fprintf(&b, "ник: @%v\n", contact.Nick)
+	}
+	if contact.Region != "" {
+		fmt.Fprintf(&b, "регион: %v\n", contact.Region)
+	}
+
+	firstSeen := contact.Created
+	if len(msgs) > 0 && (firstSeen.IsZero() || msgs[0].Time.Before(firstSeen)) {
+		firstSeen = msgs[0].Time
+	}
+	if !firstSeen.IsZero() {
+		fmt.Fprintf(&b, "впервые: %v\n", firstSeen.Format("02.01.2006"))
+	}
+
+	inbound := 0
+	for _, m := range msgs {
+		if m.Direction == database.DirIn {
+			inbound++
+		}
+	}
+	fmt.Fprintf(&b, "сообщений: %v\n", inbound)
+
+	tickets := database.SplitTickets(msgs)
+	fmt.Fprintf(&b, "тикетов: %v\n", len(tickets))
+	for i := len(tickets) - 1; i >= 0 && i >= len(tickets)-profileTickets; i-- {
+		t := tickets[i]
+		status := "закрыт"
+		if t.Open {
+			status = "открыт"
+		}
+		fmt.Fprintf(&b, "  %v: %v, %v сообщ., %v\n", t.Id(), t.Start.Format("02.01.2006"), len(t.Messages), status)
+	}
+
+	if h.isBanned(userId, contact.Nick) {
+		b.WriteString("🚫 забанен\n")
+	}
+
+	notes, err := h.profileNotes(userId)
+	if err != nil {
+		return "", errors.Wrap(err, "profileNotes")
+	}
+	b.WriteString(notes)
+	return b.String(), nil
+}
+
+//profile button pressed, data is profile:<user>
+func (h *handler) profileCallback(q *tgbotapi.CallbackQuery) error {
+	userId, err := strconv.ParseInt(strings.TrimPrefix(q.Data, profileCallback), 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "ParseInt")
+	}
+	return h.sendProfile(q.Message.Chat.ID, userId)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const queueShown = 10
+
+//queue length and waiting time of the oldest users
+func (h *handler) Queue(id int64) error {
+	pending, err := h.storage.GetPending()
+	if err != nil {
+		return errors.Wrap(err, "GetPending")
+	}
+	if len(pending) == 0 {
+		return h.send(id, queueEmpty)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Since.Before(pending[j].Since)
+	})
+
+	now := time.Now()
+	text := fmt.Sprintf("в очереди: %v\n", len(pending))
+	for i, p := range pending {
+		if i == queueShown {
+			text += "...\n"
+			break
+		}
+		assigned, err := h.cache.GetAssigned(p.Id)
+		if err != nil {
+			return errors.Wrap(err, "GetAssigned")
+		}
+		text += fmt.Sprintf("%v. %v - %v сообщ., ждёт %v", i+1, p.Id, len(p.MsgIds), now.Sub(p.Since).Round(time.Minute))
+		if assigned != "" {
+			text += ", @" + assigned
+		}
+		text += "\n"
+	}
+	return h.send(id, text)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const replyUnknown = `не удалось определить пользователя, которому адресован ответ:
+пересланное сообщение не найдено, а пользователь скрыл свой аккаунт при пересылке.
+Найдите его через /search или /user и ответьте на сообщение из /history`
+
+//user a forwarded message came from: stored mapping, then forward metadata
+func (h *handler) replyUser(chatId int64, reply *tgbotapi.Message) (int64, error) {
+	if reply == nil {
+		return 0, nil
+	}
+	userId, err := h.cache.GetUser(chatId, reply.MessageID)
+	if err != nil {
+		return 0, errors.Wrap(err, "GetUser")
+	}
+	if userId != 0 {
+		return userId, nil
+	}
+
+	userId, err = h.forwardedFrom(reply)
+	if err != nil {
+		return 0, errors.Wrap(err, "forwardedFrom")
+	}
+	if userId == 0 {
+		return 0, nil
+	}
+	err = h.cache.SetUser(chatId, reply.MessageID, userId)
+	if err != nil {
+		return 0, errors.Wrap(err, "SetUser")
+	}
+	return userId, nil
+}
+
+//sender from the forward header, by name when the user hides the account
+func (h *handler) forwardedFrom(m *tgbotapi.Message) (int64, error) {
+	if m.ForwardFrom != nil && !m.ForwardFrom.IsBot {
+		return m.ForwardFrom.ID, nil
+	}
+	name := strings.TrimSpace(m.ForwardSenderName)
+	if name == "" {
+		return 0, nil
+	}
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return 0, errors.Wrap(err, "GetContacts")
+	}
+	var found int64
+	for _, c := range contacts {
+		if strings.TrimSpace(c.Name) != name {
+			continue
+		}
+		//same name for different users, can't tell who it is
+		if found != 0 && found != c.Id {
+			return 0, nil
+		}
+		found = c.Id
+	}
+	return found, nil
+}
+
+//explain to the admin why the user of a command was not found
+func (h *handler) userNotResolved(id int64, args string, reply *tgbotapi.Message, help string) error {
+	switch {
+	case strings.TrimSpace(args) == "" && reply == nil:
+		return h.send(id, help)
+	case reply != nil:
+		return h.send(id, replyUnknown)
+	default:
+		return h.send(id, userNotFound)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/CookieNyanCloud/tg-connection-base/search"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	searchLimit = 10
+
+	searchHelp    = "/search [#тег] (текст) - поиск по сообщениям пользователей, имени, нику и региону"
+	searchEmpty   = "ничего не найдено"
+	searchResults = "найдено: %v, нажмите, чтобы открыть историю"
+)
+
+//fill the search index from storage
+func (h *handler) buildIndex() error {
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return errors.Wrap(err, "GetContacts")
+	}
+	for _, c := range contacts {
+		h.indexContact(c)
+	}
+	msgs, err := h.storage.GetAllHistory()
+	if err != nil {
+		return errors.Wrap(err, "GetAllHistory")
+	}
+	//only what users wrote, as in Feedback
+	for _, m := range msgs {
+		if m.Direction != database.DirIn {
+			continue
+		}
+		h.index.Add(m.UserId, m.Text)
+	}
+	return nil
+}
+
+func (h *handler) indexContact(c database.Contact) {
+	h.index.Add(c.Id, strings.Join([]string{c.Name, c.Nick, c.Region}, " "))
+}
+
+func (h *handler) Search(id int64, query string) error {
+	tags, query, ok := splitTagFilter(query)
+	if !ok || query == "" && len(tags) == 0 {
+		return h.send(id, searchHelp)
+	}
+
+	var results []search.Result
+	if query != "" {
+		results = h.index.Search(query, 0)
+	}
+	if len(tags) > 0 {
+		tagged, err := h.usersWithTags(tags)
+		if err != nil {
+			return errors.Wrap(err, "usersWithTags")
+		}
+		if query == "" {
+			for userId := range tagged {
+				results = append(results, search.Result{UserId: userId})
+			}
+			sort.Slice(results, func(i, j int) bool { return results[i].UserId < results[j].UserId })
+		} else {
+			filtered := results[:0]
+			for _, r := range results {
+				if tagged[r.UserId] {
+					filtered = append(filtered, r)
+				}
+			}
+			results = filtered
+		}
+	}
+	if len(results) == 0 {
+		return h.send(id, searchEmpty)
+	}
+	if len(results) > searchLimit {
+		results = results[:searchLimit]
+	}
+
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return errors.Wrap(err, "GetContacts")
+	}
+	names := make(map[int64]string, len(contacts))
+	for _, c := range contacts {
+		names[c.Id] = contactTitle(c)
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(results))
+	for _, r := range results {
+		label := names[r.UserId]
+		if label == "" {
+			label = fmt.Sprint(r.UserId)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("%v%v", historyCallback, r.UserId))))
+	}
+	msg := tgbotapi.NewMessage(id, fmt.Sprintf(searchResults, len(results)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err = h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//name and nick for buttons and cards
+func contactTitle(c database.Contact) string {
+	title := strings.TrimSpace(c.Name)
+	if c.Nick != "" {
+		title = strings.TrimSpace(title + " @" + c.Nick)
+	}
+	if c.Region != "" {
+		title += ", " + c.Region
+	}
+	if title == "" {
+		return fmt.Sprint(c.Id)
+	}
+	return title
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	slaRemindTxt   = "⏰ пользователь %v ждёт ответа уже %v"
+	slaEscalateTxt = "❗ пользователь %v ждёт ответа уже %v, ответственный: %v"
+
+	slaRemind   = 1
+	slaEscalate = 2
+)
+
+//check unanswered messages until ctx is done
+func (h *handler) RunSLA(ctx context.Context) {
+	if h.sla.Remind == 0 && h.sla.Escalate == 0 {
+		return
+	}
+	ticker := time.NewTicker(h.sla.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := h.checkSLA(now)
+			if err != nil {
+				fmt.Printf("checkSLA: %v\n", err)
+			}
+		}
+	}
+}
+
+func (h *handler) checkSLA(now time.Time) error {
+	pending, err := h.storage.GetPending()
+	if err != nil {
+		return errors.Wrap(err, "GetPending")
+	}
+	for _, p := range pending {
+		if p.Since.IsZero() {
+			continue
+		}
+		waiting := now.Sub(p.Since)
+		switch {
+		case h.sla.Escalate > 0 && waiting >= h.sla.Escalate:
+			err = h.notifySLA(p, waiting, slaEscalate)
+		case h.sla.Remind > 0 && waiting >= h.sla.Remind:
+			err = h.notifySLA(p, waiting, slaRemind)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "notifySLA %v", p.Id)
+		}
+	}
+	return nil
+}
+
+//remind the assigned admin or everyone, escalate to owners; once per level.
+//the level is claimed before sending and released when nobody got the text
+func (h *handler) notifySLA(p database.Pending, waiting time.Duration, level int) error {
+	first, err := h.cache.SetSLANotified(p.Id, p.Since, level)
+	if err != nil {
+		return errors.Wrap(err, "SetSLANotified")
+	}
+	if !first {
+		return nil
+	}
+	assigned, err := h.cache.GetAssigned(p.Id)
+	if err != nil {
+		return errors.Wrap(err, "GetAssigned")
+	}
+
+	var text string
+	var to []database.Admin
+	if level == slaEscalate {
+		if assigned == "" {
+			assigned = "нет"
+		}
+		text = fmt.Sprintf(slaEscalateTxt, p.Id, waiting.Round(time.Minute), assigned)
+		to = h.owners()
+	} else {
+		text = fmt.Sprintf(slaRemindTxt, p.Id, waiting.Round(time.Minute))
+		to = h.adminList()
+		h.mu.RLock()
+		admin, ok := h.admins[assigned]
+		h.mu.RUnlock()
+		if ok && admin.ChatId != 0 {
+			to = []database.Admin{admin}
+		}
+	}
+
+	//one failed admin does not stop the others, the level counts as notified
+	//once someone got the text
+	delivered := false
+	var firstErr error
+	for _, admin := range to {
+		if admin.ChatId == 0 {
+			continue
+		}
+		err := h.send(admin.ChatId, text)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "send %v", admin.ChatId)
+			}
+			continue
+		}
+		delivered = true
+		if len(p.MsgIds) == 0 {
+			continue
+		}
+		//last message so the admin can answer right away
+		forwarded, err := h.bot.Send(tgbotapi.NewForward(admin.ChatId, p.Id, p.MsgIds[len(p.MsgIds)-1]))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "Send %v", admin.ChatId)
+			}
+			continue
+		}
+		err = h.cache.SetUser(admin.ChatId, forwarded.MessageID, p.Id)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "SetUser %v", admin.ChatId)
+		}
+	}
+	if !delivered {
+		//the next check tries again
+		err := h.cache.ClearSLANotified(p.Id, p.Since, level)
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "ClearSLANotified")
+		}
+	}
+	return firstErr
+}
+
+func (h *handler) owners() []database.Admin {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]database.Admin, 0, len(h.sla.Owners))
+	for _, nick := range h.sla.Owners {
+		if admin, ok := h.admins[nick]; ok {
+			out = append(out, admin)
+		}
+	}
+	return out
+}
+
+//breach counters for /stat
+func (h *handler) slaBreaches(now time.Time) (string, error) {
+	pending, err := h.storage.GetPending()
+	if err != nil {
+		return "", errors.Wrap(err, "GetPending")
+	}
+	var reminded, escalated int
+	for _, p := range pending {
+		if p.Since.IsZero() {
+			continue
+		}
+		waiting := now.Sub(p.Since)
+		if h.sla.Remind > 0 && waiting >= h.sla.Remind {
+			reminded++
+		}
+		if h.sla.Escalate > 0 && waiting >= h.sla.Escalate {
+			escalated++
+		}
+	}
+	out := fmt.Sprintf("waiting = %v\n", len(pending))
+	if h.sla.Remind > 0 {
+		out += fmt.Sprintf("sla > %v = %v\n", h.sla.Remind, reminded)
+	}
+	if h.sla.Escalate > 0 {
+		out += fmt.Sprintf("sla > %v = %v\n", h.sla.Escalate, escalated)
+	}
+	return out, nil
+}
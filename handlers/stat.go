@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/charts"
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/CookieNyanCloud/tg-connection-base/stats"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	statPeriodTxt = "период: 24h, 7d или 4w, не больше года"
+	statLayout    = "02.01.2006 15:04"
+
+	chartDayLayout = "02.01"
+	chartRegions   = 10
+	//longer periods are shown by weeks
+	chartMaxDays = 62
+)
+
+//statistics for a period: /stat [chart] [7d]
+func (h *handler) Stat(id int64, args string) error {
+	parts := splitArgs(args, 2)
+	chart := len(parts) > 0 && parts[0] == "chart"
+	if chart {
+		args = ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+	}
+	period, err := stats.ParsePeriod(args)
+	if err != nil {
+		return h.send(id, statPeriodTxt)
+	}
+	contacts, err := h.storage.GetContacts()
+	if err != nil {
+		return errors.Wrap(err, "GetContacts")
+	}
+	history, err := h.storage.GetAllHistory()
+	if err != nil {
+		return errors.Wrap(err, "GetAllHistory")
+	}
+	now := time.Now()
+	if chart {
+		return h.sendCharts(id, contacts, history, now.Add(-period), now)
+	}
+	s := stats.Summarize(contacts, history, now.Add(-period), now)
+
+	breaches, err := h.slaBreaches(now)
+	if err != nil {
+		return errors.Wrap(err, "slaBreaches")
+	}
+
+	msg := tgbotapi.NewMessage(id, "<pre>"+html.EscapeString(formatSummary(s, h.hours.loc)+"\n"+breaches)+"</pre>")
+	msg.ParseMode = tgbotapi.ModeHTML
+	_, err = h.bot.Send(msg)
+	if err != nil {
+		return errors.Wrap(err, "Send")
+	}
+	return nil
+}
+
+//aligned two-column table
+func formatSummary(s stats.Summary, loc *time.Location) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "статистика с %v по %v\n\n",
+		s.From.In(loc).Format(statLayout), s.To.In(loc).Format(statLayout))
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	row := func(name string, value interface{}) {
+		fmt.Fprintf(w, "%v\t%v\n", name, value)
+	}
+	row("новых пользователей", s.NewUsers)
+	row("получено сообщений", s.Received)
+	row("отправлено ответов", s.Replies)
+	wait := "-"
+	if s.Answered > 0 {
+		wait = s.MedianFirstReply.Round(time.Minute).String()
+	}
+	row("медиана первого ответа", wait)
+	row("открытых обращений", s.Open)
+	row("заблокировали бота", s.Blocked)
+	if len(s.ByAdmin) > 0 {
+		row("", "")
+		row("ответы админов", "")
+		for _, c := range s.ByAdmin {
+			row("  @"+c.Key, c.Count)
+		}
+	}
+	if len(s.Regions) > 0 {
+		row("", "")
+		row("регионы", "")
+		for _, c := range s.Regions {
+			row("  "+c.Key, c.Count)
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
+
+//messages and response time per day or per week and regions as photos
+func (h *handler) sendCharts(id int64, contacts []database.Contact, history []database.Message, from, to time.Time) error {
+	bucket, unit := 1, "дням"
+	if to.Sub(from) > chartMaxDays*24*time.Hour {
+		bucket, unit = 7, "неделям"
+	}
+	days := stats.Buckets(contacts, history, from, to, h.hours.loc, bucket)
+	labels := make([]string, len(days))
+	received := make([]float64, len(days))
+	replies := make([]float64, len(days))
+	waits := make([]float64, len(days))
+	for i, d := range days {
+		labels[i] = d.Date.Format(chartDayLayout)
+		received[i] = float64(d.Received)
+		replies[i] = float64(d.Replies)
+		waits[i] = math.NaN()
+		if d.Answered > 0 {
+			waits[i] = d.FirstReply.Minutes()
+		}
+	}
+	regions := stats.Regions(contacts, chartRegions)
+	regionLabels := make([]string, len(regions))
+	regionCounts := make([]float64, len(regions))
+	for i, r := range regions {
+		regionLabels[i] = r.Key
+		regionCounts[i] = float64(r.Count)
+	}
+
+	messages, err := charts.Bars("Сообщения по "+unit, labels,
+		charts.Series{Name: "получено", Values: received, Color: charts.Blue},
+		charts.Series{Name: "ответов", Values: replies, Color: charts.Orange})
+	if err != nil {
+		return errors.Wrap(err, "Bars")
+	}
+	response, err := charts.Line("Медиана первого ответа по "+unit+", мин", labels,
+		charts.Series{Name: "минут", Values: waits, Color: charts.Green})
+	if err != nil {
+		return errors.Wrap(err, "Line")
+	}
+	byRegion, err := charts.HorizontalBars("Пользователи по регионам", regionLabels, regionCounts, charts.Blue)
+	if err != nil {
+		return errors.Wrap(err, "HorizontalBars")
+	}
+
+	for _, file := range []tgbotapi.FileBytes{
+		{Name: "messages.png", Bytes: messages},
+		{Name: "response.png", Bytes: response},
+		{Name: "regions.png", Bytes: byRegion},
+	} {
+		_, err = h.bot.Send(tgbotapi.NewPhoto(id, file))
+		if err != nil {
+			return errors.Wrap(err, "Send")
+		}
+	}
+	return nil
+}
+
+//the user blocked the bot, sending to them fails until they unblock it
+func isBlocked(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusForbidden
+}
+
+//remember that the user blocked the bot, a storage error is only logged
+//so that the send error is not lost
+func (h *handler) markBlocked(id int64) {
+	err := h.storage.SetBlocked(id, true)
+	if err != nil {
+		fmt.Printf("SetBlocked %v: %v\n", id, err)
+	}
+}
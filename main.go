@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -14,19 +16,28 @@ import (
 	"github.com/CookieNyanCloud/tg-connection-base/config"
 	"github.com/CookieNyanCloud/tg-connection-base/database"
 	"github.com/CookieNyanCloud/tg-connection-base/handlers"
+	"github.com/CookieNyanCloud/tg-connection-base/metrics"
 	"github.com/CookieNyanCloud/tg-connection-base/pkg"
-	"github.com/go-redis/redis/v8"
+	"github.com/CookieNyanCloud/tg-connection-base/report"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"google.golang.org/api/option"
-	"google.golang.org/api/sheets/v4"
 )
 
 var helpTxt = `
 /help - помощь
 /add (nickname) - добавить админа по нику
-/setban (nickname) - забанить пользователя по нику
-/all (text) - отправить всем пользователям текст
-/stat - статистика по боту
+/setban (nickname или id) - забанить пользователя по нику или id
+/all [#тег] (text) - отправить всем пользователям (или только с тегом) текст
+/stat [chart] [7d] - статистика за период: 24h, 7d, 4w, chart - графиками
+/next - взять пользователя, который дольше всех ждёт ответа
+/queue - очередь ожидающих ответа
+/history (id или @nick) - переписка с пользователем
+/user (id или @nick) - карточка пользователя
+/note (текст) - заметка о пользователе для админов
+/tag (теги) - теги пользователя, -тег убирает
+/search [#тег] (текст) - поиск по переписке и контактам
+/export (id, @nick или тикет) [html|json|csv] [с] [по] - выгрузить переписку файлом
+/canned - шаблоны ответов
+/r (name) - ответить шаблоном на сообщение
 `
 
 func main() {
@@ -39,44 +50,135 @@ func main() {
 		log.Fatalf("conf: %v", err)
 	}
 
-	//cache
-	redisClient, err := pkg.NewRedisClient(conf.Redis.Addr, ctx)
+	//google sheets
+	srv, err := pkg.NewSheetsService(ctx, "sheets.json", conf.Sheets)
+	if err != nil {
+		log.Fatalf("Unable to parse credantials file: %v", err)
+	}
+	schema, err := database.LoadSchema(conf.Sheets.Schema)
 	if err != nil {
-		log.Fatalf("redis client: %v", err)
+		log.Fatalf("sheets schema: %v", err)
+	}
+	//one-shot commands
+	switch flag.Arg(0) {
+	case "init-sheets":
+		err := initSheets(ctx, srv, sheetIds(conf.Sheets), schema, flag.Args()[1:])
+		if err != nil {
+			log.Fatalf("init-sheets: %v", err)
+		}
+		return
+	case "migrate":
+		err := migrateStorage(ctx, srv, conf.Sheets, schema, flag.Args()[1:])
+		if err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
 	}
-	redisCache := cache.New(ctx, redisClient.Client, conf.Redis.KeepTime)
 
-	//google sheets
-	srv, err := sheets.NewService(ctx, option.WithCredentialsFile("sheets.json"))
+	//cache
+	var botCache interface {
+		handlers.ICache
+		io.Closer
+	}
+	switch conf.Cache.Backend {
+	case config.CacheBolt:
+		botCache, err = cache.NewBolt(conf.Cache.Path)
+		if err != nil {
+			log.Fatalf("bolt cache: %v", err)
+		}
+	case config.CacheRedis:
+		redisClient, err := pkg.NewRedisClient(conf.Redis, ctx)
+		if err != nil {
+			log.Fatalf("redis client: %v", err)
+		}
+		redisCache := cache.New(ctx, redisClient.Client, conf.Redis.Prefix)
+
+		//one-shot commands
+		switch flag.Arg(0) {
+		case "migrate-cache":
+			err := migrateCache(redisCache, flag.Args()[1:])
+			if err != nil {
+				log.Fatalf("migrate-cache: %v", err)
+			}
+			return
+		}
+		botCache = redisCache
+	default:
+		log.Fatalf("unknown cache backend %q", conf.Cache.Backend)
+	}
+
+	sheetsSrv, err := database.NewSheetsSrv(srv,
+		conf.Sheets.Users, conf.Sheets.Msg, conf.Sheets.Admins, conf.Sheets.Banned,
+		conf.Sheets.Canned, conf.Sheets.History, conf.Sheets.Notes, conf.Sheets.Tags, conf.Sheets.Broadcasts,
+		schema)
 	if err != nil {
-		log.Fatalf("Unable to parse credantials file: %v", err)
+		log.Fatalf("sheets: %v", err)
+	}
+	var storage handlers.IStorage = sheetsSrv
+	if conf.Sheets.Flush > 0 {
+		wb, err := database.NewWriteBehind(sheetsSrv, conf.Sheets.Queue, conf.Sheets.Flush)
+		if err != nil {
+			log.Fatalf("sheets write-behind: %v", err)
+		}
+		go wb.Run(ctx)
+		metrics.QueueDepth(wb.Pending)
+		storage = wb
+	} else {
+		//writes failed while sheets is down are replayed after the breaker cooldown
+		sp, err := database.NewSpool(sheetsSrv, conf.Sheets.Queue, conf.Sheets.BreakerCooldown)
+		if err != nil {
+			log.Fatalf("sheets spool: %v", err)
+		}
+		go sp.Run(ctx)
+		metrics.QueueDepth(sp.Pending)
+		storage = sp
+	}
+	if conf.Metrics.Addr != "" {
+		go metrics.Serve(conf.Metrics.Addr)
+	}
+	if conf.Report.Sheet != "" {
+		go report.New(srv, storage, conf.Report, conf.Work.Location).Run(ctx)
 	}
-	sheetsSrv := database.NewSheetsSrv(srv,
-		conf.Sheets.Users, conf.Sheets.Msg, conf.Sheets.Admins, conf.Sheets.Banned)
 
 	//graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-	go func(ctx context.Context, cache *redis.Client) {
+	go func(ctx context.Context, cache io.Closer) {
 		<-quit
 		fmt.Println("shutdown")
 		const timeout = 5 * time.Second
 		ctx, shutdown := context.WithTimeout(context.Background(), timeout)
 		defer shutdown()
+		if f, ok := storage.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				fmt.Printf("flushing sheets: %v\n", err)
+			}
+		}
 		if err := cache.Close(); err != nil {
 			log.Fatalf("closing cache: %v", err)
 		}
 		os.Exit(1)
-	}(ctx, redisClient.Client)
+	}(ctx, botCache)
 
 	//tg
 	bot, updates, err := pkg.StartBot(conf.Tg.Token)
 	if err != nil {
 		log.Fatalf("tg: %v", err)
 	}
-	handler := handlers.New(redisCache, sheetsSrv, bot)
+	handler := handlers.New(botCache, storage, bot, conf)
+	go handler.RunSLA(ctx)
+	go handler.RunDigest(ctx)
 
 	for update := range updates {
+		metrics.Updates.WithLabelValues(updateType(update)).Inc()
+
+		if update.CallbackQuery != nil {
+			if handler.IsAdmin(update.CallbackQuery.From.UserName) {
+				err := handler.Callback(update.CallbackQuery)
+				logErr("Callback", err)
+			}
+			continue
+		}
 
 		if update.Message == nil {
 			continue
@@ -108,20 +210,51 @@ func main() {
 					err := handler.SetBan(chat_id, nick)
 					logErr("SetBan", err)
 				case "all":
-					err := handler.SendAll(update.Message.CommandArguments())
+					err := handler.SendAll(chat_id, update.Message.CommandArguments(), user_name)
 					logErr("SendAll", err)
 				case "stat":
-					err := handler.Stat(update.Message.Chat.ID)
+					err := handler.Stat(chat_id, update.Message.CommandArguments())
 					logErr("Stat", err)
+				case "next":
+					err := handler.Find(chat_id, user_name)
+					logErr("Find", err)
+				case "queue":
+					err := handler.Queue(chat_id)
+					logErr("Queue", err)
+				case "history":
+					err := handler.History(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage)
+					logErr("History", err)
+				case "export":
+					err := handler.Export(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage)
+					logErr("Export", err)
+				case "search":
+					err := handler.Search(chat_id, update.Message.CommandArguments())
+					logErr("Search", err)
+				case "user":
+					err := handler.Profile(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage)
+					logErr("Profile", err)
+				case "note":
+					err := handler.Note(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage, user_name)
+					logErr("Note", err)
+				case "tag":
+					err := handler.Tag(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage)
+					logErr("Tag", err)
+				case "canned":
+					err := handler.Canned(chat_id, update.Message.CommandArguments(), update.Message.ReplyToMessage)
+					logErr("Canned", err)
+				case "r":
+					err := handler.ReplyCanned(update.Message.ReplyToMessage, update.Message.CommandArguments(), chat_id, user_name)
+					logErr("ReplyCanned", err)
 				default:
 					err := handler.Unknown(update.Message.Chat.ID)
 					logErr("Unknown", err)
 				}
+				continue
 			}
 
 			// answer to user
 			if update.Message.ReplyToMessage != nil {
-				err := handler.ReplyToMsg(update.Message.ReplyToMessage.MessageID, update.Message.Text, chat_id, user_name)
+				err := handler.ReplyToMsg(update.Message.ReplyToMessage, update.Message.Text, chat_id, user_name)
 				logErr("ReplyToMsg", err)
 				continue
 			}
@@ -161,7 +294,7 @@ func main() {
 			err := handler.EndRegionDialog(update.Message.Chat.ID, region)
 			logErr("EndRegionDialog", err)
 		} else {
-			err := handler.Feedback(update.Message.Chat.ID, update.Message.MessageID)
+			err := handler.Feedback(update.Message)
 			logErr("Feedback", err)
 		}
 
@@ -170,6 +303,22 @@ func main() {
 
 func logErr(msg string, err error) {
 	if err != nil {
+		metrics.HandlerErrors.WithLabelValues(msg).Inc()
 		fmt.Printf(msg+": %v\n", err)
 	}
 }
+
+//label of an update for metrics
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.CallbackQuery != nil:
+		return "callback"
+	case update.Message == nil:
+		return "other"
+	case update.Message.IsCommand():
+		return "command"
+	case update.Message.ReplyToMessage != nil:
+		return "reply"
+	}
+	return "message"
+}
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tgbase"
+
+var (
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Telegram updates processed by type.",
+	}, []string{"type"})
+
+	HandlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_errors_total",
+		Help:      "Errors returned by handlers by function.",
+	}, []string{"func"})
+
+	telegramDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "telegram_request_duration_seconds",
+		Help:      "Telegram Bot API calls by method, without getUpdates.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	//long polling waits up to the update timeout of 600s when there are no updates
+	telegramPoll = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "telegram_poll_duration_seconds",
+		Help:      "Telegram getUpdates long polls.",
+		Buckets:   []float64{1, 5, 30, 60, 120, 300, 600, 900},
+	})
+
+	telegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_errors_total",
+		Help:      "Failed Telegram Bot API calls by method.",
+	}, []string{"method"})
+
+	sheetsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sheets_request_duration_seconds",
+		Help:      "Google Sheets API calls by http method and status, every retry is counted.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis commands by name.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed redis commands by name, missing keys are not errors.",
+	}, []string{"command"})
+
+	BroadcastTotal = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "broadcast_recipients",
+		Help:      "Recipients of the last /all.",
+	})
+
+	BroadcastDone = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "broadcast_processed",
+		Help:      "Recipients of the last /all already processed.",
+	})
+
+	BroadcastMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_messages_total",
+		Help:      "Messages of /all by result: sent, blocked or failed.",
+	}, []string{"result"})
+)
+
+//serve /metrics on addr until the process exits
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		fmt.Printf("metrics: %v\n", err)
+	}
+}
+
+//export the size of the write-behind queue
+func QueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sheets_queue_depth",
+		Help:      "Row writes waiting to be sent to sheets.",
+	}, func() float64 { return float64(depth()) })
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+//transport for the bot client, the method is the last part of the url so
+//the token never becomes a label
+func Telegram(base http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		method := path.Base(req.URL.Path)
+		start := time.Now()
+		rsp, err := base.RoundTrip(req)
+		if method == "getUpdates" {
+			telegramPoll.Observe(time.Since(start).Seconds())
+		} else {
+			telegramDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		}
+		if err != nil || rsp.StatusCode != http.StatusOK {
+			telegramErrors.WithLabelValues(method).Inc()
+		}
+		return rsp, err
+	})
+}
+
+//transport under the sheets client
+func Sheets(base http.RoundTripper) http.RoundTripper {
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		rsp, err := base.RoundTrip(req)
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(rsp.StatusCode)
+		}
+		sheetsDuration.WithLabelValues(req.Method, code).Observe(time.Since(start).Seconds())
+		return rsp, err
+	})
+}
+
+type startKey struct{}
+
+//go-redis hook timing every command
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd)
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		observeRedis(ctx, cmd)
+	}
+	return nil
+}
+
+func observeRedis(ctx context.Context, cmd redis.Cmder) {
+	start, ok := ctx.Value(startKey{}).(time.Time)
+	if !ok {
+		return
+	}
+	redisDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		redisErrors.WithLabelValues(cmd.Name()).Inc()
+	}
+}
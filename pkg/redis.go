@@ -2,21 +2,76 @@ package pkg
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+	"github.com/CookieNyanCloud/tg-connection-base/metrics"
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
 )
 
 type redisDB struct {
-	Client *redis.Client
+	Client redis.UniversalClient
 }
 
-func NewRedisClient(addr string, ctx context.Context) (*redisDB, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+//single node, sentinel failover when MasterName is set or cluster
+func NewRedisClient(conf config.RedisConfig, ctx context.Context) (*redisDB, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            conf.Addrs,
+		DB:               conf.DB,
+		Username:         conf.Username,
+		Password:         conf.Password,
+		SentinelPassword: conf.SentinelPassword,
+		MasterName:       conf.MasterName,
+	}
+	if conf.TLS.Enabled {
+		tlsConf, err := tlsConfig(conf.TLS)
+		if err != nil {
+			return nil, errors.Wrap(err, "tlsConfig")
+		}
+		opts.TLSConfig = tlsConf
+	}
+
+	var client redis.UniversalClient
+	switch {
+	case conf.Cluster:
+		client = redis.NewClusterClient(opts.Cluster())
+	case conf.MasterName != "":
+		client = redis.NewFailoverClient(opts.Failover())
+	default:
+		client = redis.NewClient(opts.Simple())
+	}
+	client.AddHook(metrics.RedisHook{})
 	if err := client.Ping(ctx).Err(); err != nil {
 		return nil, errors.Wrap(err, "NewDatabase ping")
 	}
 	return &redisDB{Client: client}, nil
 }
+
+func tlsConfig(conf config.RedisTLSConfig) (*tls.Config, error) {
+	out := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: conf.SkipVerify,
+	}
+	if conf.CA != "" {
+		pem, err := ioutil.ReadFile(conf.CA)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReadFile")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in CA file")
+		}
+		out.RootCAs = pool
+	}
+	if conf.Cert != "" || conf.Key != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Cert, conf.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "LoadX509KeyPair")
+		}
+		out.Certificates = []tls.Certificate{cert}
+	}
+	return out, nil
+}
@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+	"github.com/CookieNyanCloud/tg-connection-base/metrics"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	htransport "google.golang.org/api/transport/http"
+)
+
+const (
+	firstBackoff = 500 * time.Millisecond
+	maxBackoff   = 32 * time.Second
+)
+
+//returned without calling google while the breaker is open
+var ErrSheetsUnavailable = errors.New("sheets unavailable")
+
+//sheets client that retries quota and server errors with exponential
+//backoff and stops calling google after conf.BreakerFailures failed calls
+//in a row for conf.BreakerCooldown
+func NewSheetsService(ctx context.Context, credentials string, conf config.SheetsConfig) (*sheets.Service, error) {
+	base := &retryTransport{
+		base:    metrics.Sheets(http.DefaultTransport),
+		first:   firstBackoff,
+		retries: conf.Retries,
+		breaker: &breaker{threshold: conf.BreakerFailures, cooldown: conf.BreakerCooldown},
+	}
+	tr, err := htransport.NewTransport(ctx, base,
+		option.WithCredentialsFile(credentials),
+		option.WithScopes(sheets.SpreadsheetsScope))
+	if err != nil {
+		return nil, errors.Wrap(err, "NewTransport")
+	}
+	return sheets.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: tr}))
+}
+
+type retryTransport struct {
+	base http.RoundTripper
+	//first wait between retries
+	first   time.Duration
+	retries int
+	breaker *breaker
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.Allow() {
+		return nil, ErrSheetsUnavailable
+	}
+	wait := t.first
+	for attempt := 0; ; attempt++ {
+		try := req
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("request body can not be resent")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "GetBody")
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		rsp, err := t.base.RoundTrip(try)
+		if !failed(rsp, err) {
+			t.breaker.Success()
+			return rsp, err
+		}
+		if attempt >= t.retries || !retryable(req, rsp, err) {
+			t.breaker.Failure()
+			return rsp, err
+		}
+
+		delay := retryAfter(rsp)
+		if delay == 0 {
+			//full jitter
+			delay = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+			wait *= 2
+			if wait > maxBackoff {
+				wait = maxBackoff
+			}
+		}
+		if rsp != nil {
+			io.Copy(ioutil.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//network errors, quota errors and 5xx
+func failed(rsp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500
+}
+
+//a failed call is sent again only when a repeat can't write twice: reads,
+//writes to explicit ranges and clears. Appends and other changes are repeated
+//only when google surely did not apply them, after 429 or a refused connection
+func retryable(req *http.Request, rsp *http.Response, err error) bool {
+	if idempotent(req) {
+		return true
+	}
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	return rsp.StatusCode == http.StatusTooManyRequests
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut:
+		return true
+	}
+	p := req.URL.Path
+	for _, suffix := range []string{"/values:batchUpdate", "/values:batchClear", "/values:batchGet", ":clear"} {
+		if strings.HasSuffix(p, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+//wait asked by google, 0 if there is none
+func retryAfter(rsp *http.Response) time.Duration {
+	if rsp == nil {
+		return 0
+	}
+	sec, err := strconv.Atoi(rsp.Header.Get("Retry-After"))
+	if err != nil || sec <= 0 {
+		return 0
+	}
+	return time.Duration(sec) * time.Second
+}
+
+//opens after threshold failed calls in a row, lets one call through
+//after cooldown
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) Allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	if b.failures >= b.threshold {
+		//half-open: this call decides, the rest wait for it
+		b.openUntil = now.Add(b.cooldown)
+	}
+	return true
+}
+
+func (b *breaker) Success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.mu.Unlock()
+}
+
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
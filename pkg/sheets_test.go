@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		calls  int32
+	}{
+		{"get 500", http.MethodGet, "/v4/spreadsheets/doc/values/A1", 500, 3},
+		{"get 429", http.MethodGet, "/v4/spreadsheets/doc/values/A1", 429, 3},
+		{"get 404", http.MethodGet, "/v4/spreadsheets/doc/values/A1", 404, 1},
+		{"update 503", http.MethodPut, "/v4/spreadsheets/doc/values/A1", 503, 3},
+		{"batch update 500", http.MethodPost, "/v4/spreadsheets/doc/values:batchUpdate", 500, 3},
+		{"clear 500", http.MethodPost, "/v4/spreadsheets/doc/values/A1:clear", 500, 3},
+		{"append 500", http.MethodPost, "/v4/spreadsheets/doc/values/A1:append", 500, 1},
+		{"append 502", http.MethodPost, "/v4/spreadsheets/doc/values/A1:append", 502, 1},
+		{"append 429", http.MethodPost, "/v4/spreadsheets/doc/values/A1:append", 429, 3},
+		{"add sheet 500", http.MethodPost, "/v4/spreadsheets/doc:batchUpdate", 500, 1},
+		{"create 500", http.MethodPost, "/v4/spreadsheets", 500, 1},
+		{"append ok", http.MethodPost, "/v4/spreadsheets/doc/values/A1:append", 200, 1},
+	}
+	for _, tt := range tests {
+		var calls int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(tt.status)
+		}))
+		tr := &retryTransport{base: http.DefaultTransport, first: time.Millisecond, retries: 2, breaker: &breaker{}}
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		rsp.Body.Close()
+		ts.Close()
+		if rsp.StatusCode != tt.status {
+			t.Errorf("%v: status %v, want %v", tt.name, rsp.StatusCode, tt.status)
+		}
+		if calls != tt.calls {
+			t.Errorf("%v: %v calls, want %v", tt.name, calls, tt.calls)
+		}
+	}
+}
+
+func TestRetryTransportRefused(t *testing.T) {
+	//a closed port refuses the connection, nothing was written
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var tries int32
+	counting := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&tries, 1)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+	tr := &retryTransport{base: counting, first: time.Millisecond, retries: 2, breaker: &breaker{}}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/v4/spreadsheets/doc/values/A1:append", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("no error from a closed port")
+	}
+	if tries != 3 {
+		t.Errorf("%v tries, want 3", tries)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{threshold: 2, cooldown: time.Hour}
+	if !b.Allow() {
+		t.Fatal("closed breaker refuses")
+	}
+	b.Failure()
+	if !b.Allow() {
+		t.Fatal("opened after one failure of two")
+	}
+	b.Failure()
+	if b.Allow() {
+		t.Fatal("not opened after two failures")
+	}
+	b.Success()
+	if !b.Allow() {
+		t.Fatal("not closed after a success")
+	}
+}
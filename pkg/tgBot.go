@@ -2,6 +2,9 @@ package pkg
 
 import (
 	"log"
+	"net/http"
+
+	"github.com/CookieNyanCloud/tg-connection-base/metrics"
 
 	"github.com/pkg/errors"
 
@@ -9,7 +12,8 @@ import (
 )
 
 func StartBot(token string) (*tgbotapi.BotAPI, tgbotapi.UpdatesChannel, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
+	client := &http.Client{Transport: metrics.Telegram(http.DefaultTransport)}
+	bot, err := tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, client)
 	if err != nil {
 		return &tgbotapi.BotAPI{}, nil, errors.Wrap(err, "StartBot")
 	}
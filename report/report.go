@@ -0,0 +1,196 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/config"
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/CookieNyanCloud/tg-connection-base/stats"
+	"github.com/pkg/errors"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	tabContacts = "Контакты"
+	tabTickets  = "Тикеты"
+	tabDaily    = "По дням"
+
+	dateLayout = "2006-01-02"
+	timeLayout = "2006-01-02 15:04"
+)
+
+//what the reporting sheet is built from
+type Source interface {
+	GetContacts() ([]database.Contact, error)
+	GetAllHistory() ([]database.Message, error)
+}
+
+//one-way mirror of contacts, tickets and daily stats into a spreadsheet
+//for people who read reports, the bot never reads it back
+type Sink struct {
+	srv    *sheets.Service
+	source Source
+	conf   config.ReportConfig
+	loc    *time.Location
+}
+
+func New(srv *sheets.Service, source Source, conf config.ReportConfig, loc *time.Location) *Sink {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &Sink{srv: srv, source: source, conf: conf, loc: loc}
+}
+
+//sync now and then every conf.Interval until ctx is done
+func (s *Sink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+	for {
+		err := s.Sync()
+		if err != nil {
+			fmt.Printf("report Sync: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//replace the report tabs with current data
+func (s *Sink) Sync() error {
+	contacts, err := s.source.GetContacts()
+	if err != nil {
+		return errors.Wrap(err, "GetContacts")
+	}
+	history, err := s.source.GetAllHistory()
+	if err != nil {
+		return errors.Wrap(err, "GetAllHistory")
+	}
+	now := time.Now()
+	tabs := []struct {
+		name string
+		rows [][]interface{}
+	}{
+		{tabContacts, s.contactRows(contacts)},
+		{tabTickets, s.ticketRows(stats.Tickets(history))},
+		{tabDaily, dailyRows(stats.Daily(contacts, history, now.AddDate(0, 0, 1-s.conf.Days), now, s.loc))},
+	}
+
+	names := make([]string, 0, len(tabs))
+	for _, tab := range tabs {
+		names = append(names, tab.name)
+	}
+	err = s.ensureTabs(names)
+	if err != nil {
+		return errors.Wrap(err, "ensureTabs")
+	}
+
+	ranges := make([]string, 0, len(tabs))
+	data := make([]*sheets.ValueRange, 0, len(tabs))
+	for _, tab := range tabs {
+		r := quote(tab.name)
+		ranges = append(ranges, r)
+		data = append(data, &sheets.ValueRange{
+			MajorDimension: "ROWS",
+			Range:          r + "!A1",
+			Values:         tab.rows,
+		})
+	}
+	_, err = s.srv.Spreadsheets.Values.
+		BatchClear(s.conf.Sheet, &sheets.BatchClearValuesRequest{Ranges: ranges}).
+		Do()
+	if err != nil {
+		return errors.Wrap(err, "BatchClear")
+	}
+	_, err = s.srv.Spreadsheets.Values.BatchUpdate(s.conf.Sheet, &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "RAW",
+		Data:             data,
+	}).Do()
+	if err != nil {
+		return errors.Wrap(err, "BatchUpdate")
+	}
+	return nil
+}
+
+//add tabs that are missing
+func (s *Sink) ensureTabs(names []string) error {
+	meta, err := s.srv.Spreadsheets.Get(s.conf.Sheet).Fields("sheets.properties.title").Do()
+	if err != nil {
+		return errors.Wrap(err, "Get")
+	}
+	have := make(map[string]bool)
+	for _, sheet := range meta.Sheets {
+		have[sheet.Properties.Title] = true
+	}
+	requests := make([]*sheets.Request, 0)
+	for _, name := range names {
+		if have[name] {
+			continue
+		}
+		requests = append(requests, &sheets.Request{AddSheet: &sheets.AddSheetRequest{
+			Properties: &sheets.SheetProperties{Title: name},
+		}})
+	}
+	if len(requests) == 0 {
+		return nil
+	}
+	_, err = s.srv.Spreadsheets.BatchUpdate(s.conf.Sheet, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: requests,
+	}).Do()
+	if err != nil {
+		return errors.Wrap(err, "AddSheet")
+	}
+	return nil
+}
+
+func (s *Sink) contactRows(contacts []database.Contact) [][]interface{} {
+	rows := [][]interface{}{{"ID", "Имя", "Ник", "Регион", "Первое обращение"}}
+	for _, c := range contacts {
+		rows = append(rows, []interface{}{c.Id, c.Name, c.Nick, c.Region, s.format(c.Created, timeLayout)})
+	}
+	return rows
+}
+
+func (s *Sink) ticketRows(tickets []database.Ticket) [][]interface{} {
+	rows := [][]interface{}{{"Тикет", "Пользователь", "Начало", "Первый ответ", "Ответ, мин", "Последнее", "Статус", "Сообщений"}}
+	for _, t := range tickets {
+		var wait interface{} = ""
+		if !t.FirstReply.IsZero() {
+			wait = int(t.FirstReply.Sub(t.Start).Minutes())
+		}
+		status := "закрыт"
+		if t.Open {
+			status = "открыт"
+		}
+		rows = append(rows, []interface{}{
+			t.Id(), t.UserId, s.format(t.Start, timeLayout), s.format(t.FirstReply, timeLayout), wait,
+			s.format(t.End, timeLayout), status, len(t.Messages),
+		})
+	}
+	return rows
+}
+
+func dailyRows(days []stats.Day) [][]interface{} {
+	rows := [][]interface{}{{"Дата", "Новые пользователи", "Получено", "Ответов"}}
+	for _, d := range days {
+		rows = append(rows, []interface{}{d.Date.Format(dateLayout), d.NewUsers, d.Received, d.Replies})
+	}
+	return rows
+}
+
+//empty for unknown times
+func (s *Sink) format(t time.Time, layout string) string {
+	if t.IsZero() || t.Unix() == 0 {
+		return ""
+	}
+	return t.In(s.loc).Format(layout)
+}
+
+func quote(tab string) string {
+	return "'" + strings.Replace(tab, "'", "''", -1) + "'"
+}
@@ -0,0 +1,98 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//in-memory full-text index of users: contact fields and message texts
+type Index struct {
+	mu    sync.RWMutex
+	terms map[string]map[int64]int
+}
+
+type Result struct {
+	UserId int64
+	Score  int
+}
+
+func New() *Index {
+	return &Index{
+		terms: make(map[string]map[int64]int),
+	}
+}
+
+func (i *Index) Add(userId int64, text string) {
+	tokens := Tokenize(text)
+	if len(tokens) == 0 {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, t := range tokens {
+		users, ok := i.terms[t]
+		if !ok {
+			users = make(map[int64]int)
+			i.terms[t] = users
+		}
+		users[userId]++
+	}
+}
+
+//users matching every query word, a word matches terms it is a prefix of
+func (i *Index) Search(query string, limit int) []Result {
+	words := Tokenize(query)
+	if len(words) == 0 {
+		return nil
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var scores map[int64]int
+	for _, w := range words {
+		found := make(map[int64]int)
+		for term, users := range i.terms {
+			if !strings.HasPrefix(term, w) {
+				continue
+			}
+			for id, n := range users {
+				found[id] += n
+			}
+		}
+		if scores == nil {
+			scores = found
+			continue
+		}
+		for id := range scores {
+			if n, ok := found[id]; ok {
+				scores[id] += n
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	out := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		out = append(out, Result{UserId: id, Score: score})
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Score != out[b].Score {
+			return out[a].Score > out[b].Score
+		}
+		return out[a].UserId < out[b].UserId
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+//lower-case words of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+)
+
+//numbers of one day or of several days from Date
+type Day struct {
+	Date     time.Time
+	NewUsers int
+	Received int
+	Replies  int
+	//median wait for the first reply of tickets started that day
+	FirstReply time.Duration
+	Answered   int
+}
+
+//counts for every day from the day of from to the day of to, days start
+//at midnight in loc
+func Daily(contacts []database.Contact, history []database.Message, from, to time.Time, loc *time.Location) []Day {
+	return Buckets(contacts, history, from, to, loc, 1)
+}
+
+//like Daily with every bucket n days long, the last one can be shorter
+func Buckets(contacts []database.Contact, history []database.Message, from, to time.Time, loc *time.Location, n int) []Day {
+	if loc == nil {
+		loc = time.Local
+	}
+	if n < 1 {
+		n = 1
+	}
+	first, last := midnight(from.In(loc)), midnight(to.In(loc))
+	out := make([]Day, 0)
+	index := make(map[time.Time]int)
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		if len(index)%n == 0 {
+			out = append(out, Day{Date: d})
+		}
+		index[d] = len(out) - 1
+	}
+	for _, c := range contacts {
+		if i, ok := index[midnight(c.Created.In(loc))]; ok {
+			out[i].NewUsers++
+		}
+	}
+	for _, m := range history {
+		i, ok := index[midnight(m.Time.In(loc))]
+		if !ok {
+			continue
+		}
+		switch m.Direction {
+		case database.DirIn:
+			out[i].Received++
+		case database.DirOut:
+			out[i].Replies++
+		}
+	}
+	waits := make([][]time.Duration, len(out))
+	for _, t := range Tickets(history) {
+		i, ok := index[midnight(t.Start.In(loc))]
+		if !ok || t.FirstReply.IsZero() {
+			continue
+		}
+		waits[i] = append(waits[i], t.FirstReply.Sub(t.Start))
+	}
+	for i := range out {
+		out[i].Answered = len(waits[i])
+		out[i].FirstReply = median(waits[i])
+	}
+	return out
+}
+
+//tickets of every user, oldest first
+func Tickets(history []database.Message) []database.Ticket {
+	byUser := make(map[int64][]database.Message)
+	for _, m := range history {
+		byUser[m.UserId] = append(byUser[m.UserId], m)
+	}
+	out := make([]database.Ticket, 0)
+	for _, msgs := range byUser {
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Time.Before(msgs[j].Time) })
+		out = append(out, database.SplitTickets(msgs)...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+)
+
+func TestBuckets(t *testing.T) {
+	loc := time.UTC
+	day := func(d, h int) time.Time { return time.Date(2024, 1, d, h, 0, 0, 0, loc) }
+	history := []database.Message{
+		{UserId: 1, Direction: database.DirIn, Time: day(1, 10)},
+		{UserId: 1, Direction: database.DirOut, Time: day(1, 11)},
+		{UserId: 2, Direction: database.DirIn, Time: day(8, 10)},
+		{UserId: 2, Direction: database.DirIn, Time: day(10, 10)},
+	}
+	contacts := []database.Contact{{Id: 1, Created: day(1, 9)}, {Id: 2, Created: day(9, 9)}}
+
+	tests := []struct {
+		n        int
+		dates    []int
+		received []int
+		replies  []int
+		users    []int
+	}{
+		{1, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []int{1, 0, 0, 0, 0, 0, 0, 1, 0, 1}, []int{1, 0, 0, 0, 0, 0, 0, 0, 0, 0}, []int{1, 0, 0, 0, 0, 0, 0, 0, 1, 0}},
+		{7, []int{1, 8}, []int{1, 2}, []int{1, 0}, []int{1, 1}},
+		{0, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []int{1, 0, 0, 0, 0, 0, 0, 1, 0, 1}, []int{1, 0, 0, 0, 0, 0, 0, 0, 0, 0}, []int{1, 0, 0, 0, 0, 0, 0, 0, 1, 0}},
+		{30, []int{1}, []int{3}, []int{1}, []int{2}},
+	}
+	for _, tt := range tests {
+		got := Buckets(contacts, history, day(1, 12), day(10, 12), loc, tt.n)
+		if len(got) != len(tt.dates) {
+			t.Fatalf("n=%v: %v buckets, want %v", tt.n, len(got), len(tt.dates))
+		}
+		for i, b := range got {
+			if b.Date.Day() != tt.dates[i] || b.Received != tt.received[i] || b.Replies != tt.replies[i] || b.NewUsers != tt.users[i] {
+				t.Errorf("n=%v bucket %v = %+v, want day %v received %v replies %v users %v",
+					tt.n, i, b, tt.dates[i], tt.received[i], tt.replies[i], tt.users[i])
+			}
+		}
+	}
+	if got := Daily(contacts, history, day(1, 0), day(1, 23), loc); len(got) != 1 || got[0].Answered != 1 || got[0].FirstReply != time.Hour {
+		t.Errorf("Daily first reply = %+v, want one answered ticket after 1h", got)
+	}
+}
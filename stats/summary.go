@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+	"github.com/pkg/errors"
+)
+
+const (
+	//period of /stat without an argument
+	DefaultPeriod = 7 * 24 * time.Hour
+	//longest period accepted
+	MaxPeriod = 365 * 24 * time.Hour
+)
+
+//regions shown in a summary
+const topRegions = 5
+
+//number of one key, like replies of an admin
+type Count struct {
+	Key   string
+	Count int
+}
+
+//numbers of a period, Open, Blocked and Regions are for the moment the
+//summary is built
+type Summary struct {
+	From     time.Time
+	To       time.Time
+	NewUsers int
+	Received int
+	Replies  int
+	//median wait for the first reply of tickets started in the period
+	MedianFirstReply time.Duration
+	Answered         int
+	ByAdmin          []Count
+	Open             int
+	Regions          []Count
+	Blocked          int
+}
+
+//period like 24h, 7d or 4w up to MaxPeriod, DefaultPeriod for an empty string
+func ParsePeriod(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultPeriod, nil
+	}
+	units := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, errors.Errorf("unknown unit in %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 || int64(n) > int64(MaxPeriod/unit) {
+		return 0, errors.Errorf("bad period %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func Summarize(contacts []database.Contact, history []database.Message, from, to time.Time) Summary {
+	out := Summary{From: from, To: to}
+	in := func(t time.Time) bool {
+		return !t.Before(from) && !t.After(to)
+	}
+
+	for _, c := range contacts {
+		if in(c.Created) {
+			out.NewUsers++
+		}
+		if !c.Blocked.IsZero() {
+			out.Blocked++
+		}
+	}
+	out.Regions = Regions(contacts, topRegions)
+
+	admins := make(map[string]int)
+	for _, m := range history {
+		if !in(m.Time) {
+			continue
+		}
+		if m.Direction == database.DirIn {
+			out.Received++
+			continue
+		}
+		//broadcasts are not replies
+		if m.Direction != database.DirOut {
+			continue
+		}
+		out.Replies++
+		admin := m.Admin
+		if admin == "" {
+			admin = "-"
+		}
+		admins[admin]++
+	}
+	out.ByAdmin = top(admins, 0)
+
+	waits := make([]time.Duration, 0)
+	for _, t := range Tickets(history) {
+		if t.Open {
+			out.Open++
+		}
+		if in(t.Start) && !t.FirstReply.IsZero() {
+			waits = append(waits, t.FirstReply.Sub(t.Start))
+		}
+	}
+	out.Answered = len(waits)
+	out.MedianFirstReply = median(waits)
+	return out
+}
+
+//contacts by region, the n largest first, all of them for n <= 0
+func Regions(contacts []database.Contact, n int) []Count {
+	regions := make(map[string]int)
+	for _, c := range contacts {
+		if c.Region != "" {
+			regions[c.Region]++
+		}
+	}
+	return top(regions, n)
+}
+
+//largest counts first, all of them for n <= 0
+func top(counts map[string]int, n int) []Count {
+	out := make([]Count, 0, len(counts))
+	for k, v := range counts {
+		out = append(out, Count{Key: k, Count: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	mid := len(d) / 2
+	if len(d)%2 == 0 {
+		return (d[mid-1] + d[mid]) / 2
+	}
+	return d[mid]
+}
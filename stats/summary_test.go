@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CookieNyanCloud/tg-connection-base/database"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"", DefaultPeriod, false},
+		{"  ", DefaultPeriod, false},
+		{"24h", 24 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{" 4w ", 28 * 24 * time.Hour, false},
+		{"365d", MaxPeriod, false},
+		{"52w", 52 * 7 * 24 * time.Hour, false},
+		{"366d", 0, true},
+		{"53w", 0, true},
+		{"101w", 0, true},
+		{"8761h", 0, true},
+		{"99999999999999d", 0, true},
+		{"0d", 0, true},
+		{"-1d", 0, true},
+		{"d", 0, true},
+		{"7", 0, true},
+		{"7m", 0, true},
+		{"week", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePeriod(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParsePeriod(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePeriod(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSummarizeSkipsBroadcasts(t *testing.T) {
+	at := func(min int) time.Time { return time.Date(2024, 1, 1, 10, min, 0, 0, time.UTC) }
+	history := []database.Message{
+		{UserId: 1, Direction: database.DirIn, Time: at(0)},
+		{UserId: 1, Direction: database.DirBroadcast, Admin: "boss", Time: at(1)},
+		{UserId: 2, Direction: database.DirBroadcast, Admin: "boss", Time: at(1)},
+		{UserId: 3, Direction: database.DirIn, Time: at(2)},
+		{UserId: 3, Direction: database.DirOut, Admin: "helper", Time: at(12)},
+	}
+	s := Summarize(nil, history, at(0), at(30))
+	if s.Received != 2 || s.Replies != 1 {
+		t.Errorf("received %v replies %v, want 2 and 1", s.Received, s.Replies)
+	}
+	if len(s.ByAdmin) != 1 || s.ByAdmin[0] != (Count{Key: "helper", Count: 1}) {
+		t.Errorf("by admin = %v, want only helper with 1", s.ByAdmin)
+	}
+	if s.Open != 1 {
+		t.Errorf("open = %v, want 1", s.Open)
+	}
+	if s.Answered != 1 || s.MedianFirstReply != 10*time.Minute {
+		t.Errorf("answered %v median %v, want 1 and 10m", s.Answered, s.MedianFirstReply)
+	}
+}